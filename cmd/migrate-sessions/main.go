@@ -0,0 +1,81 @@
+// migrate-sessions copia as sessões SQLite (/app/sessions/<id>.db) para o
+// store do PostgreSQL usado com SESSION_STORE=postgres. Sessões cifradas
+// (<id>.db.enc) são lidas com a MASTER_KEY.
+//
+// Pare o servidor antes de migrar. Os arquivos migrados são renomeados para
+// <id>.db.migrated, a menos que -keep seja informado.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"wapi/config"
+	"wapi/internal/secure"
+	"wapi/internal/whatsapp"
+	"wapi/store/postgres"
+)
+
+func main() {
+	dir := flag.String("dir", "/app/sessions", "diretório com os arquivos de sessão SQLite")
+	keep := flag.Bool("keep", false, "mantém os arquivos SQLite com o nome original após migrar")
+	flag.Parse()
+
+	config.Load()
+	if err := secure.Init(config.App.MasterKey); err != nil {
+		log.Fatalf("MASTER_KEY inválida: %v", err)
+	}
+
+	if err := postgres.Connect(); err != nil {
+		log.Fatalf("Erro ao conectar no PostgreSQL: %v", err)
+	}
+	if err := postgres.Migrate(); err != nil {
+		log.Fatalf("Erro ao executar migrations: %v", err)
+	}
+
+	rows, err := postgres.DB.Query(`SELECT id, name FROM instances ORDER BY name`)
+	if err != nil {
+		log.Fatalf("Erro ao listar instâncias: %v", err)
+	}
+	type inst struct{ id, name string }
+	var instances []inst
+	for rows.Next() {
+		var i inst
+		if err := rows.Scan(&i.id, &i.name); err == nil {
+			instances = append(instances, i)
+		}
+	}
+	rows.Close()
+
+	migrated, failed := 0, 0
+	for _, i := range instances {
+		path := whatsapp.SessionDBPath(*dir, i.id)
+		if _, err := os.Stat(path); err != nil {
+			path = whatsapp.EncryptedDBPath(*dir, i.id)
+		}
+		if _, err := os.Stat(path); err != nil {
+			log.Printf("[%s] sem arquivo de sessão, ignorando", i.name)
+			continue
+		}
+
+		jid, n, err := whatsapp.MigrateSQLiteSession(i.id, path)
+		if err != nil {
+			log.Printf("[%s] erro: %v", i.name, err)
+			failed++
+			continue
+		}
+		log.Printf("[%s] sessão %s migrada (%d linha(s))", i.name, jid, n)
+		migrated++
+
+		if !*keep {
+			if err := os.Rename(path, path+".migrated"); err != nil {
+				log.Printf("[%s] aviso ao renomear %s: %v", i.name, path, err)
+			}
+		}
+	}
+
+	log.Printf("%d sessão(ões) migrada(s), %d com erro", migrated, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,56 @@
+// rotate-key recifra as sessões da MASTER_KEY atual para NEW_MASTER_KEY: os
+// arquivos SQLite (/app/sessions/<id>.db.enc) ou, com SESSION_STORE=postgres,
+// as colunas cifradas das tabelas whatsmeow_*. As chaves vêm das variáveis
+// de ambiente para não aparecerem na lista de processos.
+//
+// Sem MASTER_KEY, cifra as sessões em texto claro com NEW_MASTER_KEY. Com
+// -decrypt, decifra as sessões de volta para texto claro. Pare o servidor
+// antes de rodar e suba-o depois com MASTER_KEY igual à chave nova.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"wapi/config"
+	"wapi/internal/whatsapp"
+	"wapi/store/postgres"
+)
+
+func main() {
+	dir := flag.String("dir", "/app/sessions", "diretório com os arquivos de sessão SQLite")
+	decrypt := flag.Bool("decrypt", false, "decifra as sessões em vez de recifrar (desliga a criptografia)")
+	flag.Parse()
+
+	oldKey := os.Getenv("MASTER_KEY")
+	newKey := os.Getenv("NEW_MASTER_KEY")
+	if *decrypt {
+		newKey = ""
+	} else if newKey == "" {
+		log.Fatal("Informe a chave nova em NEW_MASTER_KEY (ou use -decrypt)")
+	}
+	if oldKey != "" && oldKey == newKey {
+		log.Fatal("NEW_MASTER_KEY é igual à MASTER_KEY atual")
+	}
+
+	config.Load()
+	var total int
+	var err error
+	if config.App.SessionStore == whatsapp.StorePostgres {
+		if err := postgres.Connect(); err != nil {
+			log.Fatalf("Erro ao conectar no PostgreSQL: %v", err)
+		}
+		total, err = whatsapp.RotatePostgresSessions(oldKey, newKey)
+		if err != nil {
+			log.Fatalf("Erro ao recifrar o store de sessões: %v", err)
+		}
+		log.Printf("%d valor(es) recifrado(s) no PostgreSQL", total)
+		return
+	}
+
+	total, err = whatsapp.RotateSessions(*dir, oldKey, newKey)
+	if err != nil {
+		log.Fatalf("Erro após %d sessão(ões): %v", total, err)
+	}
+	log.Printf("%d sessão(ões) processada(s)", total)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"log"
 	"net/http"
 	"os"
@@ -12,6 +13,11 @@ import (
 	"wapi/internal/auth"
 	"wapi/internal/handler"
 	"wapi/internal/instance"
+	"wapi/internal/queue"
+	"wapi/internal/secure"
+	"wapi/internal/storage"
+	"wapi/internal/webhook"
+	"wapi/internal/whatsapp"
 	"wapi/store/postgres"
 	_ "github.com/lib/pq"
 
@@ -21,6 +27,20 @@ import (
 func main() {
 	config.Load()
 
+	if err := secure.Init(config.App.MasterKey); err != nil {
+		log.Fatalf("MASTER_KEY inválida: %v", err)
+	}
+	if !secure.Enabled() {
+		log.Println("Aviso: MASTER_KEY não configurada, sessões ficam em texto claro")
+	}
+
+	if err := storage.Init(); err != nil {
+		log.Fatalf("Erro ao configurar o armazenamento de mídias: %v", err)
+	}
+	if storage.Enabled() {
+		log.Printf("Download automático de mídias ativo (%s)", config.App.MediaStorage)
+	}
+
 	if err := postgres.Connect(); err != nil {
 		log.Fatalf("Erro ao conectar no PostgreSQL: %v", err)
 	}
@@ -36,6 +56,20 @@ func main() {
 	}
 	log.Println("Usuário admin pronto!")
 
+	if err := queue.Recover(); err != nil {
+		log.Printf("Aviso ao recuperar fila de envio: %v", err)
+	}
+
+	if err := webhook.Recover(); err != nil {
+		log.Printf("Aviso ao recuperar entregas de webhook: %v", err)
+	}
+	if err := webhook.LoadGlobal(); err != nil {
+		log.Printf("Aviso ao carregar webhook global: %v", err)
+	}
+	// Confirmações de webhook disparam a leitura automática (auto_read)
+	webhook.Global.OnDelivered = instance.Global.WebhookDelivered
+	webhook.Global.Start()
+
 	if err := loadInstancesFromDB(); err != nil {
 		log.Printf("Aviso ao carregar instâncias: %v", err)
 	}
@@ -53,6 +87,11 @@ func main() {
 		c.Next()
 	})
 
+	// Mídias baixadas no storage local, acessadas pelas URLs assinadas dos webhooks
+	if local, ok := storage.Global.(*storage.Local); ok {
+		r.GET("/media/*filepath", handler.LocalMedia(local))
+	}
+
 	// Auth
 	authGroup := r.Group("/auth")
 	{
@@ -72,6 +111,14 @@ func main() {
 	r.POST("/instances/:name/send/text", handler.APIKeyMiddleware(), handler.SendText)
 	r.POST("/instances/:name/send/media", handler.APIKeyMiddleware(), handler.SendMedia)
 	r.POST("/instances/:name/send/media-url", handler.APIKeyMiddleware(), handler.SendMediaURL)
+	r.GET("/instances/:name/jobs/:id", handler.APIKeyMiddleware(), handler.GetJob)
+
+	// Histórico e mídia das mensagens — usa API Key (ou JWT)
+	r.GET("/instances/:name/chats/:jid/messages", handler.APIKeyMiddleware(), handler.ListChatMessages)
+	r.GET("/instances/:name/chats", handler.APIKeyMiddleware(), handler.ListChats)
+	r.GET("/instances/:name/messages/search", handler.APIKeyMiddleware(), handler.SearchMessages)
+	r.GET("/instances/:name/messages/:id/media", handler.APIKeyMiddleware(), handler.GetMessageMedia)
+	r.POST("/instances/:name/chats/:jid/read", handler.APIKeyMiddleware(), handler.MarkChatRead)
 
 	// Instâncias — usa JWT
 	instances := r.Group("/instances", handler.AuthMiddleware())
@@ -81,13 +128,33 @@ func main() {
 		instances.GET("/:name", handler.GetInstance)
 		instances.DELETE("/:name", handler.DeleteInstance)
 		instances.GET("/:name/status", handler.GetStatus)
+		instances.GET("/:name/status/history", handler.GetStatusHistory)
 		instances.POST("/:name/connect", handler.ConnectInstance)
+		instances.POST("/:name/pair", handler.PairInstance)
 		instances.POST("/:name/disconnect", handler.DisconnectInstance)
 		instances.PATCH("/:name/webhook", handler.UpdateWebhook)
+		instances.GET("/:name/webhooks", handler.ListWebhooks)
+		instances.POST("/:name/webhooks", handler.CreateWebhook)
+		instances.GET("/:name/webhooks/:id", handler.GetWebhook)
+		instances.PATCH("/:name/webhooks/:id", handler.UpdateWebhookEndpoint)
+		instances.DELETE("/:name/webhooks/:id", handler.DeleteWebhook)
+		instances.GET("/:name/deliveries", handler.ListDeliveries)
+		instances.GET("/:name/dead-letters", handler.ListDeadLetters)
+		instances.POST("/:name/dead-letters/:id/replay", handler.ReplayDeadLetter)
 		instances.POST("/:name/apikey", handler.RegenerateAPIKey)
+		instances.POST("/:name/webhook-secret", handler.RegenerateWebhookSecret)
 		instances.PATCH("/:name/config", handler.UpdateConfig)
 	}
 
+	// Administração do servidor — usa JWT
+	admin := r.Group("/admin", handler.AuthMiddleware())
+	{
+		admin.GET("/webhook", handler.GetGlobalWebhook)
+		admin.PUT("/webhook", handler.UpdateGlobalWebhook)
+		admin.POST("/instances/:name/export", handler.ExportInstance)
+		admin.POST("/instances/import", handler.ImportInstance)
+	}
+
 	// Web UI
 	handler.LoadTemplates()
 	r.GET("/login", handler.WebLogin)
@@ -121,10 +188,21 @@ func main() {
 	<-quit
 	log.Println("Sinal recebido, encerrando servidor graciosamente...")
 
+	// Para os workers de envio; jobs pendentes permanecem no banco
+	queue.Global.StopAll(15 * time.Second)
+	log.Println("Fila de envio encerrada.")
+
 	// Desconecta todas as instâncias WhatsApp antes de sair
 	instance.Global.DisconnectAll()
 	log.Println("Instâncias WhatsApp desconectadas.")
 
+	// Grava as últimas alterações das sessões cifradas
+	whatsapp.FlushSessions()
+
+	// Encerra os workers de webhook; entregas pendentes continuam no banco
+	webhook.Global.Stop()
+	log.Println("Entregas de webhook encerradas.")
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
@@ -135,7 +213,7 @@ func main() {
 
 func loadInstancesFromDB() error {
 	rows, err := postgres.DB.Query(
-		`SELECT id, name, api_key, webhook_url, transcription_enabled, typing_delay_min, typing_delay_max, status FROM instances`,
+		`SELECT id, name, api_key, webhook_url, webhook_secret, transcription_enabled, typing_delay_min, typing_delay_max, history_days, emit_from_me, auto_read, status, phone, status_reason, ban_expires_at FROM instances`,
 	)
 	if err != nil {
 		return err
@@ -146,66 +224,67 @@ func loadInstancesFromDB() error {
 	var toReconnect []*instance.Instance
 
 	for rows.Next() {
-		var id, name, apiKey, webhookURL, status string
-		var transcriptionEnabled bool
-		var typingDelayMin, typingDelayMax int
+		var id, name, apiKeyHash, webhookURL, webhookSecret, status, phone, statusReason string
+		var banExpiresAt sql.NullTime
+		var transcriptionEnabled, emitFromMe, autoRead bool
+		var typingDelayMin, typingDelayMax, historyDays int
 
-		if err := rows.Scan(&id, &name, &apiKey, &webhookURL, &transcriptionEnabled, &typingDelayMin, &typingDelayMax, &status); err != nil {
+		if err := rows.Scan(&id, &name, &apiKeyHash, &webhookURL, &webhookSecret, &transcriptionEnabled, &typingDelayMin, &typingDelayMax, &historyDays, &emitFromMe, &autoRead, &status, &phone, &statusReason, &banExpiresAt); err != nil {
 			log.Printf("Erro ao ler instância: %v", err)
 			continue
 		}
 
-		inst, err := instance.NewInstance(id, name, apiKey)
+		inst, err := instance.NewInstance(id, name, apiKeyHash)
 		if err != nil {
 			log.Printf("Erro ao carregar instância %s: %v", name, err)
 			continue
 		}
 
 		inst.WebhookURL = webhookURL
+		inst.WebhookSecret = webhookSecret
+		if webhookSecret == "" {
+			// Instâncias criadas antes da assinatura de webhooks
+			inst.WebhookSecret = webhook.NewSecret()
+			postgres.DB.Exec(`UPDATE instances SET webhook_secret = $1 WHERE id = $2`, inst.WebhookSecret, id)
+		}
 		inst.TranscriptionEnabled = transcriptionEnabled
 		inst.TypingDelayMin = typingDelayMin
 		inst.TypingDelayMax = typingDelayMax
-		inst.Status = status
+		inst.HistoryDays = historyDays
+		inst.EmitFromMe = emitFromMe
+		inst.AutoRead = autoRead
+		inst.RestoreState(status, phone, statusReason, banExpiresAt.Time)
 
 		instance.Global.Add(inst)
-
-		if status == "connected" {
+		queue.Global.Start(inst)
+
+		// Toda sessão salva volta a ser supervisionada, inclusive as que estavam
+		// caídas ou em backoff quando o processo parou. Após logout o device
+		// store é apagado; um banimento ainda em vigor espera o fim do prazo e
+		// uma instância exportada pertence ao servidor de destino.
+		banned := inst.State() == instance.StateBanned && banExpiresAt.Valid && banExpiresAt.Time.After(time.Now())
+		exported := inst.State() == instance.StateDisconnected && statusReason == instance.ReasonExported
+		if inst.WAClient.Store.ID != nil && inst.State() != instance.StateLoggedOut && !banned && !exported {
 			toReconnect = append(toReconnect, inst)
 		}
+		if banned && inst.WAClient.Store.ID != nil {
+			inst.ScheduleBanExpiry(banExpiresAt.Time)
+		}
 		count++
 	}
 
 	log.Printf("%d instância(s) carregada(s) do banco de dados", count)
 
-	// Reconecta em background as instâncias que estavam conectadas.
-	// Se não reconectar em 60s, faz logout no celular.
+	// Reconecta em background as instâncias com sessão salva. Se a
+	// conexão falhar, o supervisor de cada instância tenta novamente com
+	// backoff; a sessão nunca é deslogada por demora no startup.
 	if len(toReconnect) > 0 {
 		go func() {
 			for _, inst := range toReconnect {
 				log.Printf("[STARTUP] Reconectando instância %s...", inst.Name)
 				if err := inst.Connect(); err != nil {
 					log.Printf("[STARTUP] Erro ao reconectar %s: %v", inst.Name, err)
-					continue
 				}
-				// Aguarda até 60s para confirmar reconexão
-				go func(i *instance.Instance) {
-					deadline := time.Now().Add(60 * time.Second)
-					for time.Now().Before(deadline) {
-						time.Sleep(5 * time.Second)
-						if i.Status == "connected" {
-							log.Printf("[STARTUP] Instância %s reconectada com sucesso.", i.Name)
-							return
-						}
-					}
-					// Não reconectou em 60s: logout para notificar o celular
-					log.Printf("[STARTUP] Instância %s não reconectou em 60s, fazendo logout.", i.Name)
-					if i.WAClient != nil {
-						i.WAClient.Logout(context.Background())
-					}
-					i.Status = "disconnected"
-					i.Phone = ""
-					i.SaveStatusToDB()
-				}(inst)
 			}
 		}()
 	}
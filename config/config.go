@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -20,6 +21,41 @@ type Config struct {
 	WhisperURL       string
 	AdminUser        string
 	AdminPassword    string
+	QueueMaxAttempts int
+	SessionStore     string
+
+	WebhookMaxAttempts    int
+	WebhookBackoffSeconds int
+	WebhookTimeoutSeconds int
+	WebhookWorkers        int
+
+	GlobalWebhookURL    string
+	GlobalWebhookEvents string
+	GlobalWebhookSecret string
+
+	ReconnectMaxAttempts int
+	ReconnectBaseSeconds int
+	ReconnectMaxSeconds  int
+
+	MasterKey string
+
+	SearchLanguage string
+
+	MediaStorage        string
+	MediaDownloadTypes  string
+	MediaMaxSizeMB      int
+	MediaDir            string
+	MediaPublicURL      string
+	MediaURLExpiryHours int
+
+	S3Endpoint       string
+	S3Region         string
+	S3Bucket         string
+	S3AccessKey      string
+	S3SecretKey      string
+	S3PathStyle      bool
+	S3PublicURL      string
+	S3URLExpiryHours int
 }
 
 var App Config
@@ -42,6 +78,41 @@ func Load() {
 		WhisperURL:       getEnv("WHISPER_URL", "http://localhost:9000"),
 		AdminUser:        getEnv("ADMIN_USER", "admin"),
 		AdminPassword:    getEnv("ADMIN_PASSWORD", "admin123"),
+		QueueMaxAttempts: getEnvInt("QUEUE_MAX_ATTEMPTS", 5),
+		SessionStore:     getEnv("SESSION_STORE", "sqlite"),
+
+		WebhookMaxAttempts:    getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookBackoffSeconds: getEnvInt("WEBHOOK_BACKOFF_SECONDS", 10),
+		WebhookTimeoutSeconds: getEnvInt("WEBHOOK_TIMEOUT_SECONDS", 10),
+		WebhookWorkers:        getEnvInt("WEBHOOK_WORKERS", 4),
+
+		GlobalWebhookURL:    getEnv("GLOBAL_WEBHOOK_URL", ""),
+		GlobalWebhookEvents: getEnv("GLOBAL_WEBHOOK_EVENTS", ""),
+		GlobalWebhookSecret: getEnv("GLOBAL_WEBHOOK_SECRET", ""),
+
+		ReconnectMaxAttempts: getEnvInt("RECONNECT_MAX_ATTEMPTS", 10),
+		ReconnectBaseSeconds: getEnvInt("RECONNECT_BASE_SECONDS", 2),
+		ReconnectMaxSeconds:  getEnvInt("RECONNECT_MAX_SECONDS", 300),
+
+		MasterKey: getEnv("MASTER_KEY", ""),
+
+		SearchLanguage: getEnv("SEARCH_LANGUAGE", "portuguese"),
+
+		MediaStorage:        getEnv("MEDIA_STORAGE", ""),
+		MediaDownloadTypes:  getEnv("MEDIA_DOWNLOAD_TYPES", "image,video,document,sticker,audio"),
+		MediaMaxSizeMB:      getEnvInt("MEDIA_MAX_SIZE_MB", 50),
+		MediaDir:            getEnv("MEDIA_DIR", "/app/media"),
+		MediaPublicURL:      getEnv("MEDIA_PUBLIC_URL", ""),
+		MediaURLExpiryHours: getEnvInt("MEDIA_URL_EXPIRY_HOURS", 168),
+
+		S3Endpoint:       getEnv("S3_ENDPOINT", ""),
+		S3Region:         getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:         getEnv("S3_BUCKET", "wapi-media"),
+		S3AccessKey:      getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:      getEnv("S3_SECRET_KEY", ""),
+		S3PathStyle:      getEnv("S3_PATH_STYLE", "true") == "true",
+		S3PublicURL:      getEnv("S3_PUBLIC_URL", ""),
+		S3URLExpiryHours: getEnvInt("S3_URL_EXPIRY_HOURS", 168),
 	}
 }
 
@@ -51,3 +122,13 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvInt(key string, fallback int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+		log.Printf("Valor inválido para %s: %q, usando padrão %d", key, value, fallback)
+	}
+	return fallback
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"fmt"
 	"time"
+	"wapi/internal/secure"
 	"wapi/store/postgres"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -10,16 +11,21 @@ import (
 	"wapi/config"
 )
 
+// UserID dos tokens permanentes
+const PermanentTokenUser = "api"
+
+// APIToken é um token permanente. Só o hash fica no banco: Token é preenchido
+// apenas na criação, única vez em que o token é exibido.
 type APIToken struct {
 	ID        string `json:"id"`
 	Name      string `json:"name"`
-	Token     string `json:"token"`
+	Token     string `json:"token,omitempty"`
 	CreatedAt string `json:"created_at"`
 }
 
 func CreatePermanentToken(name string) (*APIToken, error) {
 	claims := Claims{
-		UserID:   "api",
+		UserID:   PermanentTokenUser,
 		Username: name,
 		RegisteredClaims: jwt.RegisteredClaims{
 			IssuedAt: jwt.NewNumericDate(time.Now()),
@@ -34,7 +40,7 @@ func CreatePermanentToken(name string) (*APIToken, error) {
 	id := uuid.New().String()
 	_, err = postgres.DB.Exec(
 		"INSERT INTO api_tokens (id, name, token) VALUES ($1, $2, $3)",
-		id, name, tokenStr,
+		id, name, secure.HashSecret(tokenStr),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("erro ao salvar token: %w", err)
@@ -45,7 +51,7 @@ func CreatePermanentToken(name string) (*APIToken, error) {
 
 func ListTokens() ([]APIToken, error) {
 	rows, err := postgres.DB.Query(
-		"SELECT id, name, token, created_at FROM api_tokens ORDER BY created_at DESC",
+		"SELECT id, name, created_at FROM api_tokens ORDER BY created_at DESC",
 	)
 	if err != nil {
 		return nil, fmt.Errorf("erro ao listar tokens: %w", err)
@@ -55,7 +61,7 @@ func ListTokens() ([]APIToken, error) {
 	var tokens []APIToken
 	for rows.Next() {
 		var t APIToken
-		if err := rows.Scan(&t.ID, &t.Name, &t.Token, &t.CreatedAt); err != nil {
+		if err := rows.Scan(&t.ID, &t.Name, &t.CreatedAt); err != nil {
 			continue
 		}
 		tokens = append(tokens, t)
@@ -63,6 +69,15 @@ func ListTokens() ([]APIToken, error) {
 	return tokens, nil
 }
 
+// TokenExists indica se o token permanente ainda está cadastrado
+func TokenExists(token string) bool {
+	var exists bool
+	postgres.DB.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM api_tokens WHERE token = $1)", secure.HashSecret(token),
+	).Scan(&exists)
+	return exists
+}
+
 func DeleteToken(id string) error {
 	_, err := postgres.DB.Exec("DELETE FROM api_tokens WHERE id = $1", id)
 	if err != nil {
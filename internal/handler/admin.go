@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+	"wapi/internal/instance"
+	"wapi/internal/queue"
+	"wapi/internal/transfer"
+	"wapi/internal/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetGlobalWebhook(c *gin.Context) {
+	c.JSON(http.StatusOK, webhook.GetGlobal())
+}
+
+func UpdateGlobalWebhook(c *gin.Context) {
+	var req struct {
+		URL          *string           `json:"url"`
+		Events       []string          `json:"events"`
+		Headers      map[string]string `json:"headers"`
+		Enabled      *bool             `json:"enabled"`
+		RotateSecret bool              `json:"rotate_secret"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dados inválidos"})
+		return
+	}
+
+	cfg := webhook.GetGlobal()
+	if req.URL != nil {
+		cfg.URL = *req.URL
+		cfg.Enabled = cfg.URL != ""
+	}
+	if req.Events != nil {
+		cfg.Events = req.Events
+	}
+	if req.Headers != nil {
+		cfg.Headers = req.Headers
+	}
+	if req.Enabled != nil {
+		cfg.Enabled = *req.Enabled
+	}
+	if req.RotateSecret {
+		cfg.Secret = webhook.NewSecret()
+	}
+
+	if err := webhook.SetGlobal(cfg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, webhook.GetGlobal())
+}
+
+func ExportInstance(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	var req struct {
+		Passphrase string `json:"passphrase" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "passphrase é obrigatória"})
+		return
+	}
+	if err := transfer.ValidatePassphrase(req.Passphrase); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A sessão passa a ser do servidor de destino: a instância é desconectada
+	// antes de exportar, para o arquivo levar o estado final da sessão e os
+	// dois servidores nunca usarem o mesmo device
+	wasConnected := inst.State() == instance.StateConnected
+	inst.DisconnectFor(instance.ReasonExported)
+
+	archive, err := transfer.Export(inst.ID, req.Passphrase)
+	if err != nil {
+		if wasConnected {
+			if err := inst.Connect(); err != nil {
+				log.Printf("[EXPORT] Erro ao reconectar instância %s: %v", inst.Name, err)
+			}
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.wapi", inst.Name, time.Now().Format("20060102-150405"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, "application/octet-stream", archive)
+}
+
+func ImportInstance(c *gin.Context) {
+	passphrase := c.PostForm("passphrase")
+	file, err := c.FormFile("archive")
+	if err != nil || passphrase == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "archive e passphrase são obrigatórios"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "erro ao ler arquivo"})
+		return
+	}
+	defer f.Close()
+	archive, err := io.ReadAll(f)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "erro ao ler arquivo"})
+		return
+	}
+
+	data, err := transfer.Import(archive, passphrase)
+	switch {
+	case errors.Is(err, transfer.ErrInvalidArchive), errors.Is(err, transfer.ErrWrongPassword):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, transfer.ErrInstanceExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	inst, err := instance.NewInstance(data.ID, data.Name, data.APIKeyHash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	inst.WebhookURL = data.WebhookURL
+	inst.WebhookSecret = data.WebhookSecret
+	inst.TranscriptionEnabled = data.TranscriptionEnabled
+	inst.TypingDelayMin = data.TypingDelayMin
+	inst.TypingDelayMax = data.TypingDelayMax
+	inst.HistoryDays = data.HistoryDays
+	inst.EmitFromMe = data.EmitFromMe
+	inst.AutoRead = data.AutoRead
+
+	instance.Global.Add(inst)
+	queue.Global.Start(inst)
+	if err := inst.Connect(); err != nil {
+		log.Printf("[IMPORT] Erro ao conectar instância importada %s: %v", inst.Name, err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":     inst.ID,
+		"name":   inst.Name,
+		"status": inst.State(),
+	})
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"wapi/internal/instance"
+	"wapi/internal/messages"
+	"wapi/internal/whatsapp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListChats lista as conversas da instância pela última atividade.
+// ?archived=true|false filtra os arquivados; ?offset pagina a lista.
+func ListChats(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	filter := messages.ChatFilter{Limit: queryLimit(c)}
+	if value := c.Query("archived"); value != "" {
+		archived, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "archived deve ser true ou false"})
+			return
+		}
+		filter.Archived = &archived
+	}
+	if offset, err := strconv.Atoi(c.DefaultQuery("offset", "0")); err == nil && offset > 0 {
+		filter.Offset = offset
+	}
+
+	chats, err := messages.ListChats(inst.ID, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, chats)
+}
+
+// MarkChatRead envia o recibo de leitura das mensagens recebidas no chat.
+// O corpo traz os ids em message_ids ou, em up_to, o id da mensagem até a
+// qual todas as recebidas ainda não lidas são marcadas.
+func MarkChatRead(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	chat, err := whatsapp.ParseChatJID(c.Param("jid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req struct {
+		MessageIDs []string `json:"message_ids"`
+		UpTo       string   `json:"up_to"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dados inválidos"})
+		return
+	}
+	if (len(req.MessageIDs) == 0) == (req.UpTo == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "informe message_ids ou up_to"})
+		return
+	}
+
+	var list []messages.Message
+	if req.UpTo != "" {
+		list, err = messages.UnreadUpTo(inst.ID, chat.String(), req.UpTo)
+	} else {
+		list, err = messages.FindInbound(inst.ID, chat.String(), req.MessageIDs)
+	}
+	if errors.Is(err, messages.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = inst.ReadMessages(c.Request.Context(), list)
+	if errors.Is(err, instance.ErrNotConnected) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "erro ao enviar recibo de leitura: " + err.Error()})
+		return
+	}
+
+	read := make([]string, 0, len(list))
+	found := map[string]bool{}
+	for _, m := range list {
+		read = append(read, m.MessageID)
+		found[m.MessageID] = true
+	}
+	// Ids desconhecidos ou de mensagens enviadas não geram recibo
+	notFound := []string{}
+	for _, id := range req.MessageIDs {
+		if !found[id] {
+			notFound = append(notFound, id)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"chat_jid":  chat.String(),
+		"read":      read,
+		"not_found": notFound,
+	})
+}
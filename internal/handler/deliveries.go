@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"wapi/internal/instance"
+	"wapi/internal/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ListDeliveries(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	deliveries, err := webhook.ListDeliveries(inst.ID, c.Query("status"), queryLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func ListDeadLetters(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	letters, err := webhook.ListDeadLetters(inst.ID, c.Query("replayed") == "true", queryLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, letters)
+}
+
+func ReplayDeadLetter(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	deliveryID, err := webhook.Replay(inst.ID, c.Param("id"))
+	if err == webhook.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "entrega reenfileirada", "delivery_id": deliveryID})
+}
+
+// queryLimit lê o parâmetro ?limit= (padrão 50, máximo 500)
+func queryLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 {
+		return 50
+	}
+	if limit > 500 {
+		return 500
+	}
+	return limit
+}
@@ -1,34 +1,32 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
-        "log"
+	"strings"
 	"wapi/internal/instance"
+	"wapi/internal/queue"
+	"wapi/internal/secure"
+	"wapi/internal/webhook"
         "wapi/internal/service"
 	"wapi/store/postgres"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow"
 )
 
 func ListInstances(c *gin.Context) {
 	instances := instance.Global.GetAll()
 	result := make([]gin.H, 0, len(instances))
 	for _, inst := range instances {
-		// Validação rigorosa: só considera conectado se tiver Phone E status connected
-		actualStatus := "disconnected"
-		actualPhone := inst.Phone
-		
-		if inst.Status == "connected" && inst.Phone != "" {
-			actualStatus = "connected"
-		}
-		
-		log.Printf("[LIST] Instance %s - Status: %s, Phone: %s", inst.Name, actualStatus, actualPhone)
+		info := inst.StatusInfo()
 		result = append(result, gin.H{
 			"id":     inst.ID,
 			"name":   inst.Name,
-			"status": actualStatus,
-			"phone":  actualPhone,
+			"status": info.Status,
+			"phone":  info.Phone,
 		})
 	}
 	c.JSON(http.StatusOK, result)
@@ -45,16 +43,18 @@ func CreateInstance(c *gin.Context) {
 
 	id := uuid.New().String()
 	apiKey := uuid.New().String()
+	webhookSecret := webhook.NewSecret()
 
-	inst, err := instance.NewInstance(id, req.Name, apiKey)
+	inst, err := instance.NewInstance(id, req.Name, secure.HashSecret(apiKey))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	inst.WebhookSecret = webhookSecret
 
 	_, err = postgres.DB.Exec(
-		`INSERT INTO instances (id, name, api_key) VALUES ($1, $2, $3)`,
-		id, req.Name, apiKey,
+		`INSERT INTO instances (id, name, api_key, webhook_secret) VALUES ($1, $2, $3, $4)`,
+		id, req.Name, secure.HashSecret(apiKey), webhookSecret,
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "erro ao salvar instância"})
@@ -62,12 +62,14 @@ func CreateInstance(c *gin.Context) {
 	}
 
 	instance.Global.Add(inst)
+	queue.Global.Start(inst)
 
 	c.JSON(http.StatusCreated, gin.H{
-		"id":      inst.ID,
-		"name":    inst.Name,
-		"api_key": inst.APIKey,
-		"status":  inst.Status,
+		"id":             inst.ID,
+		"name":           inst.Name,
+		"api_key":        apiKey,
+		"webhook_secret": inst.WebhookSecret,
+		"status":         inst.State(),
 	})
 }
 
@@ -78,16 +80,20 @@ func GetInstance(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
 		return
 	}
+	info := inst.StatusInfo()
 	c.JSON(http.StatusOK, gin.H{
 		"id":                    inst.ID,
 		"name":                  inst.Name,
-		"status":                inst.Status,
-		"phone":                 inst.Phone,
+		"status":                info.Status,
+		"phone":                 info.Phone,
 		"webhook_url":           inst.WebhookURL,
+		"webhook_secret":        inst.WebhookSecret,
 		"transcription_enabled": inst.TranscriptionEnabled,
 		"typing_delay_min":      inst.TypingDelayMin,
 		"typing_delay_max":      inst.TypingDelayMax,
-		"api_key":               inst.APIKey,
+		"history_days":          inst.HistoryDays,
+		"emit_from_me":          inst.EmitFromMe,
+		"auto_read":             inst.AutoRead,
 	})
 }
 
@@ -99,6 +105,7 @@ func DeleteInstance(c *gin.Context) {
 		return
 	}
 
+	queue.Global.Stop(inst.ID)
 	instance.Global.Remove(inst.ID)
 	postgres.DB.Exec(`DELETE FROM instances WHERE id = $1`, inst.ID)
 
@@ -113,16 +120,23 @@ func GetStatus(c *gin.Context) {
 		return
 	}
 
-	connected := inst.WAClient != nil && inst.WAClient.IsConnected()
-	status := inst.Status
-	if !connected {
-		status = "disconnected"
+	c.JSON(http.StatusOK, inst.StatusInfo())
+}
+
+func GetStatusHistory(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"status": status,
-		"phone":  inst.Phone,
-	})
+	history, err := instance.ListTransitions(inst.ID, queryLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, history)
 }
 
 func GetQRCode(c *gin.Context) {
@@ -133,7 +147,7 @@ func GetQRCode(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"qrcode": inst.LastQR, "status": inst.Status})
+	c.JSON(http.StatusOK, gin.H{"qrcode": inst.LastQR(), "status": inst.State()})
 }
 
 func ConnectInstance(c *gin.Context) {
@@ -152,6 +166,57 @@ func ConnectInstance(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "conectando..."})
 }
 
+// isDigits indica se s tem apenas dígitos (e ao menos um)
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func PairInstance(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	var req struct {
+		Phone string `json:"phone" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "phone é obrigatório"})
+		return
+	}
+	phone := strings.TrimPrefix(strings.ReplaceAll(req.Phone, " ", ""), "+")
+	if !isDigits(phone) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "número inválido: use apenas dígitos no formato internacional, ex.: 5511999999999"})
+		return
+	}
+
+	code, err := inst.PairPhone(c.Request.Context(), phone)
+	if errors.Is(err, instance.ErrAlreadyPaired) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, whatsmeow.ErrPhoneNumberTooShort) || errors.Is(err, whatsmeow.ErrPhoneNumberIsNotInternational) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "número inválido: use o formato internacional, ex.: 5511999999999"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": code})
+}
+
 func DisconnectInstance(c *gin.Context) {
 	name := c.Param("name")
 	inst, ok := instance.Global.GetByName(name)
@@ -161,7 +226,6 @@ func DisconnectInstance(c *gin.Context) {
 	}
 
 	inst.Disconnect()
-	postgres.DB.Exec(`UPDATE instances SET status = 'disconnected' WHERE id = $1`, inst.ID)
 
 	c.JSON(http.StatusOK, gin.H{"message": "instância desconectada"})
 }
@@ -200,6 +264,9 @@ func UpdateConfig(c *gin.Context) {
 		TranscriptionEnabled *bool `json:"transcription_enabled"`
 		TypingDelayMin       *int  `json:"typing_delay_min"`
 		TypingDelayMax       *int  `json:"typing_delay_max"`
+		HistoryDays          *int  `json:"history_days"`
+		EmitFromMe           *bool `json:"emit_from_me"`
+		AutoRead             *bool `json:"auto_read"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "dados inválidos"})
@@ -215,10 +282,23 @@ func UpdateConfig(c *gin.Context) {
 	if req.TypingDelayMax != nil {
 		inst.TypingDelayMax = *req.TypingDelayMax
 	}
+	if req.HistoryDays != nil {
+		if *req.HistoryDays < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "history_days não pode ser negativo"})
+			return
+		}
+		inst.HistoryDays = *req.HistoryDays
+	}
+	if req.EmitFromMe != nil {
+		inst.EmitFromMe = *req.EmitFromMe
+	}
+	if req.AutoRead != nil {
+		inst.AutoRead = *req.AutoRead
+	}
 
 	postgres.DB.Exec(
-		`UPDATE instances SET transcription_enabled = $1, typing_delay_min = $2, typing_delay_max = $3 WHERE id = $4`,
-		inst.TranscriptionEnabled, inst.TypingDelayMin, inst.TypingDelayMax, inst.ID,
+		`UPDATE instances SET transcription_enabled = $1, typing_delay_min = $2, typing_delay_max = $3, history_days = $4, emit_from_me = $5, auto_read = $6 WHERE id = $7`,
+		inst.TranscriptionEnabled, inst.TypingDelayMin, inst.TypingDelayMax, inst.HistoryDays, inst.EmitFromMe, inst.AutoRead, inst.ID,
 	)
 
 	c.JSON(http.StatusOK, gin.H{"message": "configurações salvas"})
@@ -232,13 +312,29 @@ func RegenerateAPIKey(c *gin.Context) {
 		return
 	}
 
+	// Só o hash é guardado: a chave nova é exibida apenas nesta resposta
 	newKey := uuid.New().String()
-	inst.APIKey = newKey
-	postgres.DB.Exec(`UPDATE instances SET api_key = $1 WHERE id = $2`, newKey, inst.ID)
+	inst.APIKeyHash = secure.HashSecret(newKey)
+	postgres.DB.Exec(`UPDATE instances SET api_key = $1 WHERE id = $2`, inst.APIKeyHash, inst.ID)
 
 	c.JSON(http.StatusOK, gin.H{"api_key": newKey})
 }
 
+func RegenerateWebhookSecret(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	newSecret := webhook.NewSecret()
+	inst.WebhookSecret = newSecret
+	postgres.DB.Exec(`UPDATE instances SET webhook_secret = $1 WHERE id = $2`, newSecret, inst.ID)
+
+	c.JSON(http.StatusOK, gin.H{"webhook_secret": newSecret})
+}
+
 func SSEHandler(c *gin.Context) {
 	name := c.Param("name")
 	inst, ok := instance.Global.GetByName(name)
@@ -256,16 +352,17 @@ func SSEHandler(c *gin.Context) {
 	inst.AddSSEClient(ch)
 	defer inst.RemoveSSEClient(ch)
 
-	if inst.LastQR != "" {
-		c.SSEvent("message", `{"event":"qr","data":{"qrcode":"`+inst.LastQR+`"}}`)
-		c.Writer.Flush()
-	}
-	
 	// Enviar status atual imediatamente ao conectar
-	if inst.Status == "connected" && inst.Phone != "" {
-		c.SSEvent("message", `{"event":"connected","data":{"phone":"`+inst.Phone+`","qrcode":""}}`)
-		c.Writer.Flush()
+	info := inst.StatusInfo()
+	status, _ := json.Marshal(gin.H{"event": "status", "data": info})
+	c.SSEvent("message", string(status))
+	if qr := inst.LastQR(); qr != "" {
+		c.SSEvent("message", `{"event":"qr","data":{"qrcode":"`+qr+`"}}`)
+	}
+	if info.Status == instance.StateConnected {
+		c.SSEvent("message", `{"event":"connected","data":{"phone":"`+info.Phone+`","qrcode":""}}`)
 	}
+	c.Writer.Flush()
 
 	ctx := c.Request.Context()
 	for {
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"net/http"
+	"wapi/internal/instance"
+	"wapi/internal/queue"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetJob(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	job, err := queue.Get(inst.ID, c.Param("id"))
+	if err == queue.ErrJobNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"net/http"
+	"wapi/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LocalMedia serve as mídias do storage local. Só URLs assinadas e dentro da
+// validade (ver storage.Local.URL) são aceitas.
+func LocalMedia(local *storage.Local) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path, err := local.Open(c.Param("filepath"), c.Query("expires"), c.Query("signature"))
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.File(path)
+	}
+}
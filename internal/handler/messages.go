@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wapi/internal/instance"
+	"wapi/internal/messages"
+	"wapi/internal/storage"
+	"wapi/internal/whatsapp"
+
+	"github.com/gin-gonic/gin"
+	"go.mau.fi/whatsmeow"
+)
+
+// ListChatMessages lista o histórico de um chat, da mensagem mais recente para
+// a mais antiga. A próxima página é pedida com ?cursor=<next_cursor>.
+func ListChatMessages(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	chat, err := whatsapp.ParseChatJID(c.Param("jid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := messages.Filter{Limit: queryLimit(c), Cursor: c.Query("cursor")}
+	if filter.Since, err = queryTime(c, "from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.Until, err = queryTime(c, "to"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	list, next, err := messages.List(inst.ID, chat.String(), filter)
+	if errors.Is(err, messages.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"chat_jid":    chat.String(),
+		"messages":    list,
+		"next_cursor": next,
+	})
+}
+
+// SearchMessages faz a busca textual nas mensagens da instância (texto,
+// legendas e transcrições). ?chat, ?sender, ?type, ?from e ?to restringem a
+// busca; ?limit e ?offset paginam o resultado.
+func SearchMessages(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	filter := messages.SearchFilter{
+		Query: strings.TrimSpace(c.Query("q")),
+		Type:  c.Query("type"),
+		Limit: queryLimit(c),
+	}
+	if filter.Query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q é obrigatório"})
+		return
+	}
+	if value := c.Query("chat"); value != "" {
+		chat, err := whatsapp.ParseChatJID(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter.ChatJID = chat.String()
+	}
+	if value := c.Query("sender"); value != "" {
+		sender, err := whatsapp.ParseChatJID(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter.Sender = sender.String()
+	}
+	var err error
+	if filter.Since, err = queryTime(c, "from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.Until, err = queryTime(c, "to"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if offset, err := strconv.Atoi(c.DefaultQuery("offset", "0")); err == nil && offset > 0 {
+		filter.Offset = offset
+	}
+
+	results, err := messages.Search(inst.ID, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"query": filter.Query, "messages": results})
+}
+
+// GetMessageMedia baixa de novo do WhatsApp a mídia de uma mensagem do
+// histórico e a devolve com o Content-Type original. ?format=base64 devolve
+// um JSON com o arquivo em base64; ?chat= desempata ids repetidos entre chats.
+func GetMessageMedia(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	chatJID := ""
+	if value := c.Query("chat"); value != "" {
+		chat, err := whatsapp.ParseChatJID(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		chatJID = chat.String()
+	}
+
+	data, msg, err := inst.DownloadMedia(c.Request.Context(), chatJID, c.Param("id"))
+	switch {
+	case errors.Is(err, messages.ErrNotFound), errors.Is(err, messages.ErrNoMedia):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, instance.ErrNotConnected):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith404), errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith410):
+		c.JSON(http.StatusGone, gin.H{"error": "mídia expirada nos servidores do WhatsApp"})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "erro ao baixar mídia: " + err.Error()})
+		return
+	}
+
+	mimetype := msg.Media.Mimetype
+	if mimetype == "" {
+		mimetype = "application/octet-stream"
+	}
+	filename := msg.Media.Filename
+	if filename == "" {
+		filename = msg.MessageID + storage.Extension(mimetype)
+	}
+
+	if c.Query("format") == "base64" {
+		c.JSON(http.StatusOK, gin.H{
+			"message_id": msg.MessageID,
+			"chat_jid":   msg.ChatJID,
+			"type":       msg.Type,
+			"mimetype":   mimetype,
+			"filename":   filename,
+			"size":       len(data),
+			"base64":     base64.StdEncoding.EncodeToString(data),
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": filename}))
+	c.Data(http.StatusOK, mimetype, data)
+}
+
+// queryTime lê um parâmetro de data em RFC 3339; ausente retorna tempo zero
+func queryTime(c *gin.Context, key string) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New(key + " inválido, use RFC 3339 (ex.: 2024-01-31T15:04:05Z)")
+	}
+	return t, nil
+}
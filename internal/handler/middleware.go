@@ -5,6 +5,7 @@ import (
 	"strings"
 	"wapi/internal/auth"
 	"wapi/internal/instance"
+	"wapi/internal/secure"
 
 	"github.com/gin-gonic/gin"
 )
@@ -31,6 +32,12 @@ func AuthMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		// Tokens permanentes valem enquanto não forem deletados
+		if claims.UserID == auth.PermanentTokenUser && !auth.TokenExists(parts[1]) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "token revogado"})
+			c.Abort()
+			return
+		}
 
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
@@ -38,13 +45,19 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// APIKeyMiddleware aceita a API key da instância ou, sem ela, o JWT de
+// gerenciamento (painel e tokens permanentes), que vale para todas as instâncias
 func APIKeyMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		apiKey := c.GetHeader("apikey")
+		var claims *auth.Claims
 		if apiKey == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "API Key não informada"})
-			c.Abort()
-			return
+			claims = bearerClaims(c)
+			if claims == nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "API Key não informada"})
+				c.Abort()
+				return
+			}
 		}
 
 		instanceName := c.Param("name")
@@ -55,7 +68,10 @@ func APIKeyMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		if inst.APIKey != apiKey {
+		if claims != nil {
+			c.Set("user_id", claims.UserID)
+			c.Set("username", claims.Username)
+		} else if !secure.CompareSecret(inst.APIKeyHash, apiKey) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "API Key inválida"})
 			c.Abort()
 			return
@@ -65,3 +81,19 @@ func APIKeyMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// bearerClaims valida o header "Authorization: Bearer <token>", se houver
+func bearerClaims(c *gin.Context) *auth.Claims {
+	parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil
+	}
+	claims, err := auth.ValidateToken(parts[1])
+	if err != nil {
+		return nil
+	}
+	if claims.UserID == auth.PermanentTokenUser && !auth.TokenExists(parts[1]) {
+		return nil
+	}
+	return claims
+}
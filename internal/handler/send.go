@@ -3,14 +3,11 @@ package handler
 import (
 	"encoding/base64"
         "log"
-	"fmt"
-	"io"
-	"path/filepath"
-	"time"
 	"net/http"
 	"strings"
+	"time"
 	"wapi/internal/instance"
-	"wapi/internal/service"
+	"wapi/internal/queue"
 
 	"github.com/gin-gonic/gin"
 )
@@ -38,11 +35,45 @@ func SendText(c *gin.Context) {
 	}
 	number := strings.TrimPrefix(req.Number, "+")
 	number = strings.ReplaceAll(number, " ", "")
-	if err := service.SendText(inst, number, req.Message); err != nil {
+	job, err := queue.EnqueueText(inst, number, req.Message)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "mensagem enviada com sucesso"})
+	respondJob(c, inst, job, gin.H{
+		"success": true,
+		"message": "mensagem enfileirada para envio",
+	})
+}
+
+// respondJob responde com o job enfileirado. Com ?wait=true aguarda o envio
+// (até 60s) para já retornar o ID da mensagem, o timestamp do servidor e o JID do destinatário.
+func respondJob(c *gin.Context, inst *instance.Instance, job *queue.Job, resp gin.H) {
+	if c.Query("wait") == "true" {
+		done, err := queue.Wait(c.Request.Context(), inst.ID, job.ID, 60*time.Second)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "job_id": job.ID})
+			return
+		}
+		job = done
+	}
+
+	resp["job_id"] = job.ID
+	resp["status"] = job.Status
+	switch job.Status {
+	case queue.StatusSent:
+		resp["message"] = "mensagem enviada com sucesso"
+		if job.Result != nil {
+			resp["message_id"] = job.Result.MessageID
+			resp["timestamp"] = job.Result.Timestamp
+			resp["recipient_jid"] = job.Result.RecipientJID
+		}
+		c.JSON(http.StatusOK, resp)
+	case queue.StatusFailed:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": job.LastError, "job_id": job.ID, "status": job.Status})
+	default:
+		c.JSON(http.StatusAccepted, resp)
+	}
 }
 
 func SendMedia(c *gin.Context) {
@@ -79,45 +110,17 @@ func SendMedia(c *gin.Context) {
 		return
 	}
 	
-	var data []byte
-	var mimetype string
-	var filename string
-	var err error
+	media := queue.MediaInput{
+		Caption:   req.Caption,
+		MediaType: req.Type,
+	}
 	
-	// Se é URL, verificar se deve processar em background
 	if strings.HasPrefix(mediaInput, "http://") || strings.HasPrefix(mediaInput, "https://") {
-		// Responde imediatamente e processa em background
-		log.Printf("[ASYNC] URL detected, responding immediately and processing in background")
-		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"message": "mídia em processamento, será enviada em breve",
-		})
-		number := strings.TrimPrefix(req.Number, "+")
-		number = strings.ReplaceAll(number, " ", "")
-		caption := req.Caption
-		mediaType := req.Type
-		go func() {
-			gData, gMime, gFilename, gErr := downloadFromURL(mediaInput)
-			if gErr != nil {
-				log.Printf("[ASYNC ERROR] Download failed: %v", gErr)
-				return
-			}
-			gIsAudio := mediaType == "audio" ||
-				strings.Contains(gMime, "audio") ||
-				strings.HasSuffix(gFilename, ".ogg") ||
-				strings.HasSuffix(gFilename, ".mp3") ||
-				strings.HasSuffix(gFilename, ".m4a") ||
-				strings.HasSuffix(gFilename, ".opus")
-			if gErr = service.SendMedia(inst, number, gData, gMime, gFilename, caption, gIsAudio); gErr != nil {
-				log.Printf("[ASYNC ERROR] SendMedia failed: %v", gErr)
-			} else {
-				log.Printf("[ASYNC SUCCESS] Media sent - type: %s, size: %d bytes", gMime, len(gData))
-			}
-		}()
-		return
+		// URL: o download é feito pelo worker da fila
+		media.URL = mediaInput
 	} else {
 		// É Base64 - decodificar
-		data, err = base64.StdEncoding.DecodeString(mediaInput)
+		data, err := base64.StdEncoding.DecodeString(mediaInput)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "media base64 inválido"})
 			return
@@ -128,92 +131,30 @@ func SendMedia(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "mimetype é obrigatório para Base64"})
 			return
 		}
-		mimetype = req.Mimetype
-		filename = req.Filename
+		media.Data = data
+		media.Mimetype = req.Mimetype
+		media.Filename = req.Filename
 	}
 	
 	// Limpar número
 	number := strings.TrimPrefix(req.Number, "+")
 	number = strings.ReplaceAll(number, " ", "")
 	
-	// Detectar se é áudio
-	isAudio := req.Type == "audio" ||
-		strings.Contains(mimetype, "audio") ||
-		strings.HasSuffix(filename, ".ogg") ||
-		strings.HasSuffix(filename, ".mp3") ||
-		strings.HasSuffix(filename, ".m4a") ||
-		strings.HasSuffix(filename, ".opus")
-	mediaType := classifyMediaType(mimetype, filename)
-
-	// Vídeo grande: responde imediatamente e processa em background
-	if strings.HasPrefix(mimetype, "video/") && len(data) > 16*1024*1024 {
-		log.Printf("[ASYNC] Large video (%d bytes), responding immediately", len(data))
-		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"message": "vídeo em processamento, será enviado em breve",
-			"media_type": mediaType,
-		})
-		go func() {
-			if err := service.SendMedia(inst, number, data, mimetype, filename, req.Caption, isAudio); err != nil {
-				log.Printf("[ASYNC ERROR] SendMedia failed: %v", err)
-			} else {
-				log.Printf("[ASYNC SUCCESS] Large video sent - size: %d bytes", len(data))
-			}
-		}()
-		return
-	}
-
-	// Mídia normal: comportamento síncrono
-	if err := service.SendMedia(inst, number, data, mimetype, filename, req.Caption, isAudio); err != nil {
-		log.Printf("[ERROR] SendMedia failed: %v", err)
+	job, err := queue.EnqueueMedia(inst, number, media)
+	if err != nil {
+		log.Printf("[ERROR] Enqueue media failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	log.Printf("[SUCCESS] Media sent - type: %s, size: %d bytes", mimetype, len(data))
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "mídia enviada com sucesso",
-		"media_type": mediaType,
-	})
-}
-
-// Helper: Download de mídia da URL
-func downloadFromURL(url string) ([]byte, string, string, error) {
-	client := &http.Client{
-		Timeout: 300 * time.Second,
-	}
-	
-	resp, err := client.Get(url)
-	if err != nil {
-		return nil, "", "", fmt.Errorf("erro ao baixar mídia: %w", err)
-	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		return nil, "", "", fmt.Errorf("erro HTTP %d ao baixar mídia", resp.StatusCode)
-	}
-	
-	// Verificar tamanho (limite 25MB)
-	if resp.ContentLength > 200*1024*1024 {
-		return nil, "", "", fmt.Errorf("arquivo excede o limite de 200MB")
-	}
 	
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, "", "", fmt.Errorf("erro ao ler dados: %w", err)
+	resp := gin.H{
+		"success": true,
+		"message": "mídia enfileirada para envio",
 	}
-	
-	// Pegar mimetype do header
-	mimetype := resp.Header.Get("Content-Type")
-	if mimetype == "" || mimetype == "application/octet-stream" {
-		// Fallback: detectar pelos primeiros bytes
-		mimetype = http.DetectContentType(data)
+	if media.URL == "" {
+		resp["media_type"] = classifyMediaType(media.Mimetype, media.Filename)
 	}
-	
-	// Extrair filename da URL
-	filename := filepath.Base(url)
-	
-	return data, mimetype, filename, nil
+	respondJob(c, inst, job, resp)
 }
 
 // Helper: Classificar tipo de mídia baseado no mimetype
@@ -266,30 +207,19 @@ func SendMediaURL(c *gin.Context) {
 		return
 	}
 	
-	// Download da mídia
-	data, mimetype, filename, err := downloadFromURL(req.URL)
-	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
-		return
-	}
-	
-	// Classificar tipo de mídia
-	mediaType := classifyMediaType(mimetype, filename)
-	
 	// Limpar número
 	number := strings.TrimPrefix(req.Number, "+")
 	number = strings.ReplaceAll(number, " ", "")
 	
-	// Enviar mídia usando o service existente
-	isAudio := (mediaType == "audio" || mediaType == "ptt")
-	if err := service.SendMedia(inst, number, data, mimetype, filename, req.Caption, isAudio); err != nil {
+	// Download e envio ficam a cargo do worker da fila
+	job, err := queue.EnqueueMedia(inst, number, queue.MediaInput{URL: req.URL, Caption: req.Caption})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	
-	c.JSON(http.StatusOK, gin.H{
+	respondJob(c, inst, job, gin.H{
 		"success": true,
-		"message": "mídia enviada com sucesso",
-		"media_type": mediaType,
+		"message": "mídia enfileirada para envio",
 	})
 }
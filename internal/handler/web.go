@@ -79,14 +79,11 @@ func WebConnections(c *gin.Context) {
 	all := instance.Global.GetAll()
 	views := make([]InstView, 0, len(all))
 	for _, inst := range all {
-		status := "disconnected"
-		if inst.Status == "connected" && inst.Phone != "" {
-			status = "connected"
-		}
+		info := inst.StatusInfo()
 		views = append(views, InstView{
 			Name:   inst.Name,
-			Status: status,
-			Phone:  inst.Phone,
+			Status: string(info.Status),
+			Phone:  info.Phone,
 		})
 	}
 
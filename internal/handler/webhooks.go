@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"net/http"
+	"wapi/internal/instance"
+	"wapi/internal/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+type webhookRequest struct {
+	URL     *string           `json:"url"`
+	Events  []string          `json:"events"`
+	Headers map[string]string `json:"headers"`
+	Enabled *bool             `json:"enabled"`
+}
+
+func ListWebhooks(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	endpoints, err := webhook.ListEndpoints(inst.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, endpoints)
+}
+
+func CreateWebhook(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.URL == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url é obrigatória"})
+		return
+	}
+
+	e := &webhook.Endpoint{
+		InstanceID: inst.ID,
+		URL:        *req.URL,
+		Events:     req.Events,
+		Headers:    req.Headers,
+		Enabled:    true,
+	}
+	if e.Events == nil {
+		e.Events = []string{}
+	}
+	if e.Headers == nil {
+		e.Headers = map[string]string{}
+	}
+	if req.Enabled != nil {
+		e.Enabled = *req.Enabled
+	}
+
+	if err := webhook.CreateEndpoint(e); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, e)
+}
+
+func GetWebhook(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	e, err := webhook.GetEndpoint(inst.ID, c.Param("id"))
+	if err == webhook.ErrEndpointNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+func UpdateWebhookEndpoint(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	e, err := webhook.GetEndpoint(inst.ID, c.Param("id"))
+	if err == webhook.ErrEndpointNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dados inválidos"})
+		return
+	}
+	if req.URL != nil {
+		e.URL = *req.URL
+	}
+	if req.Events != nil {
+		e.Events = req.Events
+	}
+	if req.Headers != nil {
+		e.Headers = req.Headers
+	}
+	if req.Enabled != nil {
+		e.Enabled = *req.Enabled
+	}
+
+	if err := webhook.UpdateEndpoint(e); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+func DeleteWebhook(c *gin.Context) {
+	name := c.Param("name")
+	inst, ok := instance.Global.GetByName(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instância não encontrada"})
+		return
+	}
+
+	err := webhook.DeleteEndpoint(inst.ID, c.Param("id"))
+	if err == webhook.ErrEndpointNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "webhook removido"})
+}
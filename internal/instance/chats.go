@@ -0,0 +1,123 @@
+package instance
+
+import (
+	"context"
+	"log"
+	"time"
+	"wapi/internal/messages"
+
+	"go.mau.fi/whatsmeow/proto/waHistorySync"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// handleChatEvent mantém a lista de chats com as alterações feitas em outros
+// aparelhos (app state) e nos grupos
+func (inst *Instance) handleChatEvent(evt interface{}) {
+	switch v := evt.(type) {
+	case *events.Archive:
+		archived := v.Action.GetArchived()
+		inst.updateChat(v.JID, messages.ChatUpdate{Archived: &archived})
+	case *events.Pin:
+		pinned := v.Action.GetPinned()
+		inst.updateChat(v.JID, messages.ChatUpdate{Pinned: &pinned})
+	case *events.Mute:
+		muted := v.Action.GetMuted()
+		inst.updateChat(v.JID, messages.ChatUpdate{Muted: &muted, MutedUntil: muteUntil(v.Action.GetMuteEndTimestamp())})
+	case *events.MarkChatAsRead:
+		read := v.Action.GetRead()
+		inst.updateChat(v.JID, messages.ChatUpdate{Read: &read})
+	case *events.Contact:
+		name := v.Action.GetFullName()
+		if name == "" {
+			name = v.Action.GetFirstName()
+		}
+		if name != "" {
+			inst.updateChat(v.JID, messages.ChatUpdate{Name: &name})
+		}
+	case *events.GroupInfo:
+		if v.Name != nil {
+			inst.updateChat(v.JID, messages.ChatUpdate{Name: &v.Name.Name, IsGroup: true})
+		}
+	case *events.JoinedGroup:
+		inst.updateChat(v.JID, messages.ChatUpdate{Name: &v.Name, IsGroup: true})
+	}
+}
+
+func (inst *Instance) updateChat(jid types.JID, u messages.ChatUpdate) {
+	if jid.IsEmpty() || jid.Server == types.BroadcastServer {
+		return
+	}
+	if err := messages.UpdateChat(inst.ID, inst.chatJID(jid).String(), u); err != nil {
+		log.Printf("[CHAT] Instance %s: %v", inst.Name, err)
+	}
+}
+
+// syncChats grava o estado das conversas recebidas na sincronização de histórico
+func (inst *Instance) syncChats(conversations []*waHistorySync.Conversation) {
+	for _, conv := range conversations {
+		jid, err := types.ParseJID(conv.GetID())
+		if err != nil {
+			continue
+		}
+		// Conversas endereçadas por LID podem trazer o número junto
+		jid = conversationJID(jid, conv)
+
+		u := messages.ChatUpdate{}
+		if name := conv.GetName(); name != "" {
+			u.Name = &name
+		} else if name := conv.GetDisplayName(); name != "" {
+			u.Name = &name
+		}
+		archived := conv.GetArchived()
+		pinned := conv.GetPinned() > 0
+		unread := int(conv.GetUnreadCount())
+		if unread == 0 && conv.GetMarkedAsUnread() {
+			unread = 1
+		}
+		u.Archived, u.Pinned, u.UnreadCount = &archived, &pinned, &unread
+		if until := muteUntil(int64(conv.GetMuteEndTime())); until != nil {
+			muted := until.After(time.Now())
+			u.Muted, u.MutedUntil = &muted, until
+		}
+		ts := conv.GetConversationTimestamp()
+		if ts == 0 {
+			ts = conv.GetLastMsgTimestamp()
+		}
+		if ts > 0 {
+			activity := time.Unix(int64(ts), 0)
+			u.LastActivityAt = &activity
+		}
+		inst.updateChat(jid, u)
+	}
+}
+
+// ensureGroupName busca o nome do grupo na primeira mensagem recebida dele
+func (inst *Instance) ensureGroupName(jid types.JID) {
+	if messages.ChatName(inst.ID, jid.String()) != "" {
+		return
+	}
+	go func() {
+		info, err := inst.WAClient.GetGroupInfo(context.Background(), jid)
+		if err != nil {
+			log.Printf("[CHAT] Instance %s: erro ao buscar grupo %s: %v", inst.Name, jid, err)
+			return
+		}
+		inst.updateChat(jid, messages.ChatUpdate{Name: &info.Name, IsGroup: true})
+	}()
+}
+
+// muteUntil converte o fim do silenciamento (segundos ou milissegundos, de
+// acordo com a origem). Zero ou negativo significa sem prazo.
+func muteUntil(ts int64) *time.Time {
+	if ts <= 0 {
+		return nil
+	}
+	var t time.Time
+	if ts > 1e12 {
+		t = time.UnixMilli(ts)
+	} else {
+		t = time.Unix(ts, 0)
+	}
+	return &t
+}
@@ -0,0 +1,214 @@
+package instance
+
+import (
+	"wapi/internal/messages"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+)
+
+// Tipos de mensagem que não entram no histórico: revoke e edit alteram uma
+// mensagem existente e os demais protocolos são internos do WhatsApp
+const (
+	typeRevoke   = "revoke"
+	typeEdit     = "edit"
+	typeProtocol = "protocol"
+	typeUnknown  = "unknown"
+)
+
+// messageContent é o conteúdo reconhecido de uma mensagem: o tipo, o texto ou
+// legenda, os metadados da mídia e os campos próprios do tipo no webhook
+type messageContent struct {
+	Type     string
+	Body     string
+	Media    *messages.Media
+	Download *messages.MediaDownload
+	Fields   map[string]interface{}
+}
+
+// storable informa se a mensagem é gravada no histórico
+func (mc messageContent) storable() bool {
+	switch mc.Type {
+	case typeRevoke, typeEdit, typeProtocol, typeUnknown:
+		return false
+	}
+	return true
+}
+
+// parseContent reconhece as variantes comuns de waE2E.Message. A mensagem já
+// deve estar desembrulhada (efêmera, visualização única, documento com legenda).
+func parseContent(msg *waE2E.Message) messageContent {
+	mc := messageContent{Type: typeUnknown, Fields: map[string]interface{}{}}
+
+	switch {
+	case msg.GetConversation() != "":
+		mc.Type, mc.Body = "text", msg.GetConversation()
+	case msg.GetExtendedTextMessage() != nil:
+		mc.Type, mc.Body = "text", msg.GetExtendedTextMessage().GetText()
+	case msg.GetImageMessage() != nil:
+		img := msg.GetImageMessage()
+		mc.Type, mc.Body = "image", img.GetCaption()
+		mc.Media = mediaInfo(img.GetMimetype(), "", img.GetFileLength(), img.GetFileSHA256())
+	case msg.GetAudioMessage() != nil:
+		audio := msg.GetAudioMessage()
+		mc.Type = "audio"
+		mc.Media = mediaInfo(audio.GetMimetype(), "", audio.GetFileLength(), audio.GetFileSHA256())
+		mc.Fields["seconds"] = audio.GetSeconds()
+		mc.Fields["ptt"] = audio.GetPTT()
+	case msg.GetVideoMessage() != nil:
+		video := msg.GetVideoMessage()
+		mc.Type, mc.Body = "video", video.GetCaption()
+		mc.Media = mediaInfo(video.GetMimetype(), "", video.GetFileLength(), video.GetFileSHA256())
+		mc.Fields["seconds"] = video.GetSeconds()
+		mc.Fields["gif"] = video.GetGifPlayback()
+	case msg.GetDocumentMessage() != nil:
+		doc := msg.GetDocumentMessage()
+		mc.Type, mc.Body = "document", doc.GetCaption()
+		mc.Media = mediaInfo(doc.GetMimetype(), doc.GetFileName(), doc.GetFileLength(), doc.GetFileSHA256())
+		if doc.GetTitle() != "" {
+			mc.Fields["title"] = doc.GetTitle()
+		}
+		if doc.GetPageCount() > 0 {
+			mc.Fields["page_count"] = doc.GetPageCount()
+		}
+	case msg.GetStickerMessage() != nil:
+		sticker := msg.GetStickerMessage()
+		mc.Type = "sticker"
+		mc.Media = mediaInfo(sticker.GetMimetype(), "", sticker.GetFileLength(), sticker.GetFileSHA256())
+		mc.Fields["animated"] = sticker.GetIsAnimated()
+	case msg.GetLocationMessage() != nil:
+		loc := msg.GetLocationMessage()
+		mc.Type, mc.Body = "location", firstNonEmpty(loc.GetName(), loc.GetAddress())
+		mc.Fields["latitude"] = loc.GetDegreesLatitude()
+		mc.Fields["longitude"] = loc.GetDegreesLongitude()
+		mc.Fields["name"] = loc.GetName()
+		mc.Fields["address"] = loc.GetAddress()
+		if loc.GetURL() != "" {
+			mc.Fields["url"] = loc.GetURL()
+		}
+	case msg.GetLiveLocationMessage() != nil:
+		live := msg.GetLiveLocationMessage()
+		mc.Type, mc.Body = "live_location", live.GetCaption()
+		mc.Fields["latitude"] = live.GetDegreesLatitude()
+		mc.Fields["longitude"] = live.GetDegreesLongitude()
+		mc.Fields["accuracy_meters"] = live.GetAccuracyInMeters()
+		mc.Fields["speed_mps"] = live.GetSpeedInMps()
+		mc.Fields["sequence_number"] = live.GetSequenceNumber()
+	case msg.GetContactMessage() != nil:
+		contact := msg.GetContactMessage()
+		mc.Type, mc.Body = "contact", contact.GetDisplayName()
+		mc.Fields["display_name"] = contact.GetDisplayName()
+		mc.Fields["vcard"] = contact.GetVcard()
+	case msg.GetContactsArrayMessage() != nil:
+		array := msg.GetContactsArrayMessage()
+		mc.Type, mc.Body = "contacts", array.GetDisplayName()
+		contacts := make([]map[string]string, 0, len(array.GetContacts()))
+		for _, contact := range array.GetContacts() {
+			contacts = append(contacts, map[string]string{
+				"display_name": contact.GetDisplayName(),
+				"vcard":        contact.GetVcard(),
+			})
+		}
+		mc.Fields["contacts"] = contacts
+	case pollCreation(msg) != nil:
+		poll := pollCreation(msg)
+		mc.Type, mc.Body = "poll", poll.GetName()
+		options := make([]string, 0, len(poll.GetOptions()))
+		for _, opt := range poll.GetOptions() {
+			options = append(options, opt.GetOptionName())
+		}
+		mc.Fields["poll_name"] = poll.GetName()
+		mc.Fields["poll_options"] = options
+		mc.Fields["selectable_count"] = poll.GetSelectableOptionsCount()
+	case msg.GetReactionMessage() != nil:
+		reaction := msg.GetReactionMessage()
+		mc.Type, mc.Body = "reaction", reaction.GetText()
+		// Texto vazio significa que a reação foi removida
+		mc.Fields["reaction"] = reaction.GetText()
+		mc.Fields["reaction_message_id"] = reaction.GetKey().GetID()
+		mc.Fields["reaction_from_me"] = reaction.GetKey().GetFromMe()
+	case msg.GetButtonsResponseMessage() != nil:
+		reply := msg.GetButtonsResponseMessage()
+		mc.Type, mc.Body = "button_reply", reply.GetSelectedDisplayText()
+		mc.Fields["selected_id"] = reply.GetSelectedButtonID()
+	case msg.GetTemplateButtonReplyMessage() != nil:
+		reply := msg.GetTemplateButtonReplyMessage()
+		mc.Type, mc.Body = "button_reply", reply.GetSelectedDisplayText()
+		mc.Fields["selected_id"] = reply.GetSelectedID()
+	case msg.GetListResponseMessage() != nil:
+		reply := msg.GetListResponseMessage()
+		mc.Type, mc.Body = "list_reply", reply.GetTitle()
+		mc.Fields["selected_id"] = reply.GetSingleSelectReply().GetSelectedRowID()
+	case msg.GetProtocolMessage() != nil:
+		proto := msg.GetProtocolMessage()
+		switch proto.GetType() {
+		case waE2E.ProtocolMessage_REVOKE:
+			mc.Type = typeRevoke
+			mc.Fields["target_message_id"] = proto.GetKey().GetID()
+		case waE2E.ProtocolMessage_MESSAGE_EDIT:
+			edited := parseContent(proto.GetEditedMessage())
+			mc.Type, mc.Body = typeEdit, edited.Body
+			mc.Fields["target_message_id"] = proto.GetKey().GetID()
+		default:
+			mc.Type = typeProtocol
+		}
+	}
+	if mc.Media != nil {
+		mc.Download = DownloadInfo(msg)
+	}
+	return mc
+}
+
+// DownloadInfo extrai da mensagem os dados para baixar a mídia de novo
+// (direct path, media key e hashes). Retorna nil se não houver mídia.
+func DownloadInfo(msg *waE2E.Message) *messages.MediaDownload {
+	var d whatsmeow.DownloadableMessage
+	var length uint64
+	switch {
+	case msg.GetImageMessage() != nil:
+		d, length = msg.GetImageMessage(), msg.GetImageMessage().GetFileLength()
+	case msg.GetAudioMessage() != nil:
+		d, length = msg.GetAudioMessage(), msg.GetAudioMessage().GetFileLength()
+	case msg.GetVideoMessage() != nil:
+		d, length = msg.GetVideoMessage(), msg.GetVideoMessage().GetFileLength()
+	case msg.GetDocumentMessage() != nil:
+		d, length = msg.GetDocumentMessage(), msg.GetDocumentMessage().GetFileLength()
+	case msg.GetStickerMessage() != nil:
+		d, length = msg.GetStickerMessage(), msg.GetStickerMessage().GetFileLength()
+	default:
+		return nil
+	}
+	if d.GetDirectPath() == "" || len(d.GetMediaKey()) == 0 {
+		return nil
+	}
+	return &messages.MediaDownload{
+		DirectPath:    d.GetDirectPath(),
+		MediaKey:      d.GetMediaKey(),
+		FileSHA256:    d.GetFileSHA256(),
+		FileEncSHA256: d.GetFileEncSHA256(),
+		FileLength:    length,
+		MediaType:     string(whatsmeow.GetMediaType(d)),
+	}
+}
+
+// pollCreation retorna a enquete em qualquer uma das versões da mensagem
+func pollCreation(msg *waE2E.Message) *waE2E.PollCreationMessage {
+	switch {
+	case msg.GetPollCreationMessage() != nil:
+		return msg.GetPollCreationMessage()
+	case msg.GetPollCreationMessageV2() != nil:
+		return msg.GetPollCreationMessageV2()
+	case msg.GetPollCreationMessageV3() != nil:
+		return msg.GetPollCreationMessageV3()
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package instance
+
+import (
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// handleGroupEvent publica as mudanças nos grupos: entrada da instância em um
+// grupo (groups.upsert), alterações de nome, descrição e configurações
+// (groups.update) e de participantes (groups.participants)
+func (inst *Instance) handleGroupEvent(evt interface{}) {
+	switch v := evt.(type) {
+	case *events.JoinedGroup:
+		participants := make([]map[string]interface{}, 0, len(v.Participants))
+		for _, p := range v.Participants {
+			jid := p.JID
+			if !p.PhoneNumber.IsEmpty() {
+				jid = p.PhoneNumber
+			}
+			participants = append(participants, map[string]interface{}{
+				"number":         inst.chatJID(jid).User,
+				"is_admin":       p.IsAdmin,
+				"is_super_admin": p.IsSuperAdmin,
+			})
+		}
+		data := inst.groupData(v.JID, v.Sender, v.SenderPN)
+		data["name"] = v.Name
+		data["topic"] = v.Topic
+		data["owner"] = inst.chatJID(v.OwnerJID).User
+		data["announce"] = v.IsAnnounce
+		data["locked"] = v.IsLocked
+		data["participants"] = participants
+		data["reason"] = v.Reason
+		inst.PublishEvent("groups.upsert", data)
+	case *events.GroupInfo:
+		inst.publishGroupUpdate(v)
+		inst.publishGroupParticipants(v)
+	}
+}
+
+func (inst *Instance) publishGroupUpdate(v *events.GroupInfo) {
+	data := inst.groupData(v.JID, v.Sender, v.SenderPN)
+	data["timestamp"] = v.Timestamp.Format(time.RFC3339)
+	changed := false
+	if v.Name != nil {
+		data["name"], changed = v.Name.Name, true
+	}
+	if v.Topic != nil {
+		data["topic"], changed = v.Topic.Topic, true
+	}
+	if v.Announce != nil {
+		data["announce"], changed = v.Announce.IsAnnounce, true
+	}
+	if v.Locked != nil {
+		data["locked"], changed = v.Locked.IsLocked, true
+	}
+	if v.Ephemeral != nil {
+		data["disappearing_seconds"], changed = v.Ephemeral.DisappearingTimer, true
+	}
+	if v.Delete != nil {
+		data["deleted"], changed = v.Delete.Deleted, true
+	}
+	if changed {
+		inst.PublishEvent("groups.update", data)
+	}
+}
+
+func (inst *Instance) publishGroupParticipants(v *events.GroupInfo) {
+	actions := []struct {
+		action string
+		jids   []types.JID
+	}{
+		{"add", v.Join},
+		{"remove", v.Leave},
+		{"promote", v.Promote},
+		{"demote", v.Demote},
+	}
+	for _, a := range actions {
+		if len(a.jids) == 0 {
+			continue
+		}
+		numbers := make([]string, 0, len(a.jids))
+		for _, jid := range a.jids {
+			numbers = append(numbers, inst.chatJID(jid).User)
+		}
+		data := inst.groupData(v.JID, v.Sender, v.SenderPN)
+		data["action"] = a.action
+		data["participants"] = numbers
+		data["timestamp"] = v.Timestamp.Format(time.RFC3339)
+		inst.PublishEvent("groups.participants", data)
+	}
+}
+
+// groupData monta os campos comuns aos eventos de grupo. author é quem fez a
+// alteração, pelo número quando o WhatsApp informa só o LID.
+func (inst *Instance) groupData(group types.JID, sender, senderPN *types.JID) map[string]interface{} {
+	data := map[string]interface{}{
+		"group_jid": group.String(),
+	}
+	switch {
+	case senderPN != nil && !senderPN.IsEmpty():
+		data["author"] = senderPN.User
+	case sender != nil && !sender.IsEmpty():
+		data["author"] = inst.chatJID(*sender).User
+	}
+	return data
+}
@@ -0,0 +1,89 @@
+package instance
+
+import (
+	"log"
+	"time"
+	"wapi/internal/messages"
+
+	"go.mau.fi/whatsmeow/proto/waHistorySync"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Dias de mensagens importadas da sincronização de histórico em instâncias novas
+const DefaultHistoryDays = 30
+
+// handleHistorySync importa as conversas e mensagens enviadas pelo celular
+// depois do pareamento e publica o progresso (history.sync). O whatsmeow
+// entrega os blocos em sequência, fora do loop principal de eventos.
+func (inst *Instance) handleHistorySync(v *events.HistorySync) {
+	conversations := v.Data.GetConversations()
+	inst.syncChats(conversations)
+
+	imported, skipped := 0, 0
+	if inst.HistoryDays > 0 {
+		since := time.Now().AddDate(0, 0, -inst.HistoryDays)
+		for _, conv := range conversations {
+			i, s := inst.importConversation(conv, since)
+			imported += i
+			skipped += s
+		}
+	}
+
+	log.Printf("[HISTORY] Instance %s: %s bloco %d (%d%%), %d conversa(s), %d mensagem(ns) importada(s)",
+		inst.Name, v.Data.GetSyncType(), v.Data.GetChunkOrder(), v.Data.GetProgress(), len(conversations), imported)
+
+	inst.PublishEvent("history.sync", map[string]interface{}{
+		"sync_type":         v.Data.GetSyncType().String(),
+		"chunk_order":       v.Data.GetChunkOrder(),
+		"progress":          v.Data.GetProgress(),
+		"conversations":     len(conversations),
+		"messages_imported": imported,
+		"messages_skipped":  skipped,
+	})
+}
+
+// importConversation grava as mensagens da conversa a partir de since.
+// Mensagens de sistema e de protocolo (sem conteúdo reconhecido) ficam de fora.
+func (inst *Instance) importConversation(conv *waHistorySync.Conversation, since time.Time) (imported, skipped int) {
+	chat, err := types.ParseJID(conv.GetID())
+	if err != nil || chat.Server == types.BroadcastServer {
+		return 0, len(conv.GetMessages())
+	}
+	chatJID := inst.chatJID(conversationJID(chat, conv)).String()
+
+	for _, hm := range conv.GetMessages() {
+		evt, err := inst.WAClient.ParseWebMessage(chat, hm.GetMessage())
+		if err != nil || evt.Info.Timestamp.Before(since) {
+			skipped++
+			continue
+		}
+		_, rec, mc := inst.parseMessage(evt)
+		if mc.Type == typeEdit || mc.Type == typeRevoke {
+			inst.applyChange(chatJID, mc, evt.Info.Timestamp)
+			continue
+		}
+		if !mc.storable() {
+			skipped++
+			continue
+		}
+		rec.ChatJID = chatJID
+		if err := messages.SaveHistory(rec); err != nil {
+			log.Printf("[HISTORY] Instance %s: %v", inst.Name, err)
+			skipped++
+			continue
+		}
+		imported++
+	}
+	return imported, skipped
+}
+
+// conversationJID troca o LID da conversa pelo número quando o histórico o traz
+func conversationJID(jid types.JID, conv *waHistorySync.Conversation) types.JID {
+	if jid.Server == types.HiddenUserServer && conv.GetPnJID() != "" {
+		if pn, err := types.ParseJID(conv.GetPnJID()); err == nil {
+			return pn
+		}
+	}
+	return jid
+}
@@ -1,15 +1,15 @@
 package instance
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
 	"sync"
 	"time"
-	"wapi/internal/transcriber"
+	"wapi/internal/messages"
+	"wapi/internal/webhook"
 	"wapi/internal/whatsapp"
 	"wapi/store/postgres"
 
@@ -19,23 +19,56 @@ import (
 	"go.mau.fi/whatsmeow/types/events"
 )
 
+// Tempo máximo aguardando o primeiro QR Code antes de pedir o código de pareamento
+const pairWaitTimeout = 30 * time.Second
+
+var (
+	ErrAlreadyPaired = errors.New("instância já está pareada")
+	ErrNotConnected  = errors.New("instância não conectada")
+)
+
 type Instance struct {
 	ID                   string
 	Name                 string
-	APIKey               string
+	APIKeyHash           string // hash da API key (secure.HashSecret)
 	WebhookURL           string
+	WebhookSecret        string
 	TranscriptionEnabled bool
 	TypingDelayMin       int
 	TypingDelayMax       int
-	Status               string
-	Phone                string
-	LastQR               string
+	HistoryDays          int  // dias de mensagens importadas do histórico (0 desliga)
+	EmitFromMe           bool // publica as mensagens enviadas pelo celular ou outros aparelhos
+	AutoRead             bool // marca como lidas as mensagens recebidas depois que o webhook as confirma
 	WAClient             *whatsmeow.Client
 	Container            *sqlstore.Container
 	ctx                  context.Context
 	cancel               context.CancelFunc
 	SSEClients           map[chan string]struct{}
 	sseMu                sync.Mutex
+
+	// Estado de conexão, acessado apenas via State/StatusInfo e transition
+	stateMu        sync.Mutex
+	status         State
+	statusReason   string
+	banExpiresAt   time.Time
+	phone          string
+	lastQR         string
+	stateChangedAt time.Time
+
+	// Supervisor de reconexão (ver supervisor.go)
+	reconnectCh        chan struct{}
+	reconnectAttempts  int
+	reconnectNextAt    time.Time
+	reconnectLastError string
+	reconnectSlow      bool
+	banTimer           *time.Timer
+
+	// Leitura automática (ver WebhookDelivered)
+	autoReadMu sync.Mutex
+
+	// Contatos com presença assinada na conexão atual (ver presence.go)
+	presenceSubs map[types.JID]bool
+	presenceMu   sync.Mutex
 }
 
 type Manager struct {
@@ -101,11 +134,12 @@ func (m *Manager) Remove(id string) {
 		if inst.WAClient != nil {
 			inst.WAClient.Disconnect()
 		}
+		whatsapp.CloseContainer(inst.Container)
 		delete(m.instances, id)
 	}
 }
 
-func NewInstance(id, name, apiKey string) (*Instance, error) {
+func NewInstance(id, name, apiKeyHash string) (*Instance, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	client, container, err := whatsapp.NewClient(id)
 	if err != nil {
@@ -115,30 +149,32 @@ func NewInstance(id, name, apiKey string) (*Instance, error) {
 	inst := &Instance{
 		ID:                   id,
 		Name:                 name,
-		APIKey:               apiKey,
-		Status:               "disconnected",
+		APIKeyHash:           apiKeyHash,
+		status:               StateCreated,
+		stateChangedAt:       time.Now(),
 		TranscriptionEnabled: true,
 		TypingDelayMin:       1000,
 		TypingDelayMax:       3000,
+		HistoryDays:          DefaultHistoryDays,
 		WAClient:             client,
 		Container:            container,
 		ctx:                  ctx,
 		cancel:               cancel,
 		SSEClients:           make(map[chan string]struct{}),
+		reconnectCh:          make(chan struct{}, 1),
 	}
 	return inst, nil
 }
 
-// SaveStatusToDB salva o status atual no banco (exportado para uso externo)
-func (inst *Instance) SaveStatusToDB() {
-	inst.saveStatusToDB()
-}
-
-// Helper: salvar status no banco de dados
-func (inst *Instance) saveStatusToDB() {
-	postgres.DB.Exec(`UPDATE instances SET status = $1, phone = $2 WHERE id = $3`,
-		inst.Status, inst.Phone, inst.ID)
-	log.Printf("[DB] Instance %s status saved: %s, phone: %s", inst.Name, inst.Status, inst.Phone)
+// Helper: salvar status no banco de dados (chamado com stateMu travado)
+func (inst *Instance) saveStatusLocked() {
+	var banExpiresAt interface{}
+	if !inst.banExpiresAt.IsZero() {
+		banExpiresAt = inst.banExpiresAt
+	}
+	postgres.DB.Exec(`UPDATE instances SET status = $1, phone = $2, status_reason = $3, ban_expires_at = $4 WHERE id = $5`,
+		inst.status, inst.phone, inst.statusReason, banExpiresAt, inst.ID)
+	log.Printf("[DB] Instance %s status saved: %s, phone: %s", inst.Name, inst.status, inst.phone)
 }
 
 func (inst *Instance) Connect() error {
@@ -154,9 +190,18 @@ func (inst *Instance) Connect() error {
 	if err != nil {
 		return err
 	}
+	// A reconexão fica a cargo do supervisor
+	client.EnableAutoReconnect = false
+	previous := inst.Container
 	inst.WAClient = client
 	inst.Container = container
+	if previous != container {
+		whatsapp.CloseContainer(previous)
+	}
 	inst.WAClient.AddEventHandler(inst.handleEvent)
+	inst.resetReconnect()
+	inst.transition(StateConnecting, stateChange{})
+	go inst.supervise(ctx, client)
 
 	if inst.WAClient.Store.ID == nil {
 		qrChan, _ := inst.WAClient.GetQRChannel(inst.ctx)
@@ -170,181 +215,323 @@ func (inst *Instance) Connect() error {
 						return
 					}
 					if evt.Event == "code" {
-						inst.LastQR = evt.Code
-						inst.BroadcastSSE(`{"event":"qr","data":{"qrcode":"` + evt.Code + `"}}`)
+						inst.setQR(evt.Code)
 					}
 				}
 			}
 		}()
 	}
 
-	err = inst.WAClient.Connect()
+	if err = inst.WAClient.Connect(); err != nil {
+		inst.transition(StateDisconnected, stateChange{Reason: err.Error()})
+		// Sessão existente: o supervisor continua tentando em background
+		inst.requestReconnect()
+		return err
+	}
+	return nil
+}
 
-	// Verificar e atualizar status após Connect
-	go func() {
-		time.Sleep(3 * time.Second)
-		if inst.WAClient.IsConnected() && inst.WAClient.Store.ID != nil {
-			inst.Status = "connected"
-			inst.Phone = inst.WAClient.Store.ID.User
-			log.Printf("[CONNECT] Instance %s connected - Phone: %s", inst.Name, inst.Phone)
-			inst.BroadcastSSE(fmt.Sprintf(`{"event":"connected","data":{"phone":"%s"}}`, inst.Phone))
-			inst.saveStatusToDB()
-		} else {
-			inst.Status = "disconnected"
-			log.Printf("[CONNECT] Instance %s not authenticated yet (waiting for QR scan)", inst.Name)
-			inst.saveStatusToDB()
+// PairPhone inicia o pareamento por código: conecta se necessário, aguarda o
+// primeiro QR Code (exigido pelo whatsmeow) e pede o código de 8 caracteres
+// que deve ser digitado no celular em "Conectar com número de telefone"
+func (inst *Instance) PairPhone(ctx context.Context, phone string) (string, error) {
+	if inst.WAClient != nil && inst.WAClient.Store.ID != nil {
+		return "", ErrAlreadyPaired
+	}
+	if inst.State() != StateAwaitingQR {
+		if err := inst.Connect(); err != nil {
+			return "", err
 		}
-	}()
+	}
+
+	deadline := time.After(pairWaitTimeout)
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for inst.State() != StateAwaitingQR {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-deadline:
+			return "", fmt.Errorf("timeout aguardando conexão para pareamento")
+		case <-ticker.C:
+		}
+	}
+
+	code, err := inst.WAClient.PairPhone(ctx, phone, true, whatsmeow.PairClientChrome, "Chrome (Linux)")
+	if err != nil {
+		return "", fmt.Errorf("erro ao gerar código de pareamento: %w", err)
+	}
+	log.Printf("[PAIR] Instance %s pairing code generated for %s", inst.Name, phone)
 
-	go inst.keepAlive()
-	return err
+	data := map[string]interface{}{"code": code, "phone": phone}
+	sse, _ := json.Marshal(map[string]interface{}{"event": "pairing_code", "data": data})
+	inst.BroadcastSSE(string(sse))
+	go inst.sendWebhook("pairingcode.updated", data)
+	return code, nil
 }
 
 func (inst *Instance) Disconnect() {
+	inst.DisconnectFor("")
+}
+
+// DisconnectFor desconecta a instância registrando o motivo no estado
+func (inst *Instance) DisconnectFor(reason string) {
 	inst.cancel()
 	if inst.WAClient != nil {
 		inst.WAClient.Disconnect()
 	}
-	inst.Status = "disconnected"
-	inst.Phone = ""
-	inst.LastQR = ""
-	inst.saveStatusToDB()
-}
-
-func (inst *Instance) keepAlive() {
-	ticker := time.NewTicker(20 * time.Second)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-inst.ctx.Done():
-			return
-		case <-ticker.C:
-			if inst.WAClient.IsConnected() {
-				inst.WAClient.SendPresence(context.Background(), types.PresenceAvailable)
-				if inst.Status != "connected" && inst.WAClient.Store.ID != nil {
-					inst.Status = "connected"
-					inst.Phone = inst.WAClient.Store.ID.User
-					log.Printf("[KEEPALIVE] Instance %s reconnected - Phone: %s", inst.Name, inst.Phone)
-					inst.BroadcastSSE(fmt.Sprintf(`{"event":"connected","data":{"phone":"%s"}}`, inst.Phone))
-					inst.saveStatusToDB()
-				}
-			} else {
-				if inst.Status == "connected" {
-					inst.Status = "disconnected"
-					inst.Phone = ""
-					log.Printf("[KEEPALIVE] Instance %s disconnected", inst.Name)
-					inst.BroadcastSSE(`{"event":"disconnected","data":{}}`)
-					inst.saveStatusToDB()
-				}
-			}
-		}
-	}
+	inst.transition(StateDisconnected, stateChange{Reason: reason})
 }
 
 func (inst *Instance) handleEvent(evt interface{}) {
 	switch v := evt.(type) {
 	case *events.Connected:
-		inst.Status = "connected"
-		if inst.WAClient.Store.ID != nil {
-			inst.Phone = inst.WAClient.Store.ID.User
-		}
-		log.Printf("[EVENT] Instance %s Connected - Phone: %s", inst.Name, inst.Phone)
-		inst.BroadcastSSE(fmt.Sprintf(`{"event":"connected","data":{"phone":"%s","qrcode":""}}`, inst.Phone))
-		inst.saveStatusToDB()
+		log.Printf("[EVENT] Instance %s Connected", inst.Name)
+		inst.markConnected()
+		go inst.subscribePresences(inst.WAClient)
+	case *events.PairSuccess:
+		log.Printf("[EVENT] Instance %s PairSuccess - Phone: %s", inst.Name, v.ID.User)
+		whatsapp.SaveDeviceJID(inst.ID, v.ID)
+		inst.transition(StatePairing, stateChange{})
 	case *events.Disconnected:
-		inst.Status = "disconnected"
-		inst.Phone = ""
+		// Após logout, substituição ou banimento a transição é ignorada e o motivo prevalece
 		log.Printf("[EVENT] Instance %s Disconnected", inst.Name)
-		inst.BroadcastSSE(`{"event":"disconnected","data":{}}`)
-		inst.saveStatusToDB()
+		if inst.transition(StateDisconnected, stateChange{}) {
+			inst.requestReconnect()
+		}
+	case *events.KeepAliveTimeout:
+		log.Printf("[EVENT] Instance %s KeepAliveTimeout - falhas: %d", inst.Name, v.ErrorCount)
+		if v.ErrorCount >= keepAliveMaxErrors {
+			// Conexão morta: derruba o socket fora do handler e deixa o supervisor reconectar
+			go func(client *whatsmeow.Client) {
+				client.Disconnect()
+				if inst.transition(StateDisconnected, stateChange{Reason: "keepalive sem resposta"}) {
+					inst.requestReconnect()
+				}
+			}(inst.WAClient)
+		}
+	case *events.LoggedOut:
+		log.Printf("[EVENT] Instance %s LoggedOut - Reason: %s", inst.Name, v.Reason)
+		// Fora do handler de eventos: MarkLoggedOut desconecta o client
+		go inst.MarkLoggedOut(v.Reason.String())
+	case *events.StreamReplaced:
+		log.Printf("[EVENT] Instance %s StreamReplaced", inst.Name)
+		inst.transition(StateReplaced, stateChange{Reason: "sessão substituída por outra conexão"})
+	case *events.TemporaryBan:
+		log.Printf("[EVENT] Instance %s TemporaryBan - %s", inst.Name, v)
+		expiresAt := time.Now().Add(v.Expire)
+		if inst.transition(StateBanned, stateChange{Reason: v.Code.String(), BanExpiresAt: expiresAt}) && v.Expire > 0 {
+			inst.ScheduleBanExpiry(expiresAt)
+		}
 	case *events.Message:
-		if v.Info.IsFromMe {
+		if v.Info.IsFromMe && !inst.EmitFromMe {
 			return
 		}
 		inst.processMessage(v)
+	case *events.Archive, *events.Pin, *events.Mute, *events.MarkChatAsRead, *events.Contact:
+		inst.handleChatEvent(evt)
+	case *events.GroupInfo, *events.JoinedGroup:
+		inst.handleChatEvent(evt)
+		inst.handleGroupEvent(evt)
+	case *events.Presence, *events.ChatPresence:
+		inst.handlePresence(evt)
+	case *events.HistorySync:
+		inst.handleHistorySync(v)
+	case *events.Receipt:
+		inst.handleReceipt(v)
 	}
 }
 
 func (inst *Instance) processMessage(v *events.Message) {
+	msgData, rec, mc := inst.parseMessage(v)
+	if mc.Type == typeProtocol {
+		return
+	}
+	log.Printf("[MESSAGE] remote_jid=%s, sender=%s, isGroup=%v, pushName=%s, type=%s",
+		msgData["remote_jid"], msgData["sender_number"], v.Info.IsGroup, v.Info.PushName, mc.Type)
+
+	if mc.storable() {
+		inst.saveMessage(rec)
+	} else if mc.Type == typeEdit || mc.Type == typeRevoke {
+		inst.applyChange(rec.ChatJID, mc, v.Info.Timestamp)
+	}
+	if v.Info.IsGroup {
+		inst.ensureGroupName(v.Info.Chat)
+	} else if !v.Info.IsFromMe {
+		go inst.subscribePresence(inst.WAClient, v.Info.Chat)
+	}
+	if inst.needsDownload(mc) {
+		go inst.processMedia(v, msgData, rec)
+		return
+	}
+
+	inst.broadcastMessage(msgData)
+	go inst.sendWebhook("messages.upsert", msgData)
+}
+
+// parseMessage monta o payload do webhook e o registro do histórico da mensagem
+func (inst *Instance) parseMessage(v *events.Message) (map[string]interface{}, *messages.Message, messageContent) {
 	isGroup := v.Info.Chat.Server == "g.us"
 	remoteJID := v.Info.Chat.User
 
-	var senderNumber string
+	senderJID := v.Info.Sender.ToNonAD()
 	if v.Info.Sender.Server == "lid" {
 		phoneJID, err := inst.Container.LIDMap.GetPNForLID(context.Background(), v.Info.Sender)
 		if err == nil && !phoneJID.IsEmpty() {
-			senderNumber = phoneJID.User
+			senderJID = phoneJID.ToNonAD()
 			log.Printf("[LID RESOLVED] %s → %s", v.Info.Sender.User, phoneJID.User)
 		} else {
-			senderNumber = v.Info.Sender.User
 			log.Printf("[LID NOT FOUND] %s (erro: %v)", v.Info.Sender.User, err)
 		}
-	} else {
-		senderNumber = v.Info.Sender.ToNonAD().User
 	}
+	senderNumber := senderJID.User
 
-	log.Printf("[MESSAGE] remote_jid=%s, sender=%s, isGroup=%v, pushName=%s",
-		remoteJID, senderNumber, isGroup, v.Info.PushName)
-
+	mc := parseContent(v.Message)
 	msgData := map[string]interface{}{
 		"remote_jid":    remoteJID,
+		"chat_jid":      inst.chatJID(v.Info.Chat).String(),
 		"sender_number": senderNumber,
 		"pushName":      v.Info.PushName,
 		"is_group":      isGroup,
 		"timestamp":     v.Info.Timestamp.Format(time.RFC3339),
 		"messageId":     v.Info.ID,
-		"type":          "text",
-		"message":       "",
-	}
-
-	if v.Message.GetConversation() != "" {
-		msgData["message"] = v.Message.GetConversation()
-	} else if v.Message.GetExtendedTextMessage() != nil {
-		msgData["message"] = v.Message.GetExtendedTextMessage().GetText()
-	} else if v.Message.GetImageMessage() != nil {
-		msgData["message"] = "[imagem]"
-		if v.Message.GetImageMessage().GetCaption() != "" {
-			msgData["message"] = v.Message.GetImageMessage().GetCaption()
+		"from_me":       v.Info.IsFromMe,
+		"type":          mc.Type,
+		"message":       mc.Body,
+	}
+	// Sem texto, message descreve o tipo ("[imagem]", "[localização]"...)
+	if mc.Body == "" && mc.Type != "reaction" {
+		msgData["message"] = messages.TypeLabel(mc.Type)
+	}
+	for key, value := range mc.Fields {
+		msgData[key] = value
+	}
+	if mc.Media != nil {
+		msgData["mimetype"] = mc.Media.Mimetype
+		msgData["size"] = mc.Media.Size
+		if mc.Media.Filename != "" {
+			msgData["filename"] = mc.Media.Filename
+		}
+		if mc.Media.SHA256 != "" {
+			msgData["sha256"] = mc.Media.SHA256
 		}
-	} else if v.Message.GetAudioMessage() != nil {
-		msgData["message"] = "[áudio]"
-		msgData["type"] = "audio"
-		go inst.processAudio(v, msgData)
-		return
 	}
 
-	inst.broadcastMessage(msgData)
-	go inst.sendWebhook(msgData)
-}
-
-func (inst *Instance) processAudio(v *events.Message, msgData map[string]interface{}) {
-	audioMsg := v.Message.GetAudioMessage()
-	audioData, err := inst.WAClient.Download(context.Background(), audioMsg)
-	if err != nil {
-		inst.broadcastMessage(msgData)
-		go inst.sendWebhook(msgData)
-		return
+	rec := &messages.Message{
+		InstanceID: inst.ID,
+		MessageID:  v.Info.ID,
+		ChatJID:    inst.chatJID(v.Info.Chat).String(),
+		SenderJID:  senderJID.String(),
+		PushName:   v.Info.PushName,
+		Direction:  messages.Inbound,
+		Type:       mc.Type,
+		Body:       mc.Body,
+		Media:      mc.Media,
+		Download:   mc.Download,
+		Status:     messages.StatusReceived,
+		Timestamp:  v.Info.Timestamp,
 	}
-	if inst.TranscriptionEnabled {
-		text, _ := transcriber.Transcribe(audioData, "audio.ogg")
-		msgData["transcription"] = text
+	// Remetente como veio do WhatsApp, usado nos recibos de leitura
+	rec.RawSenderJID = v.Info.Sender.ToNonAD().String()
+	if v.Info.IsFromMe {
+		rec.Direction = messages.Outbound
+		rec.Status = messages.StatusServerAck
 	}
-	inst.broadcastMessage(msgData)
-	go inst.sendWebhook(msgData)
+	return msgData, rec, mc
 }
 
-func (inst *Instance) sendWebhook(msgData map[string]interface{}) {
-	if inst.WebhookURL == "" {
-		return
-	}
+func (inst *Instance) sendWebhook(event string, data map[string]interface{}) {
 	payload := map[string]interface{}{
 		"instance":   inst.Name,
 		"instanceId": inst.ID,
-		"event":      "messages.upsert",
-		"data":       msgData,
+		"event":      event,
+		"data":       data,
+	}
+	jsonBytes, _ := json.Marshal(payload)
+	if err := webhook.Dispatch(inst.ID, inst.WebhookURL, event, jsonBytes); err != nil {
+		log.Printf("[WEBHOOK] Instance %s: %v", inst.Name, err)
+	}
+}
+
+// markConnected transita para connected com o número da sessão atual
+func (inst *Instance) markConnected() {
+	inst.resetReconnect()
+	phone := ""
+	if inst.WAClient.Store.ID != nil {
+		phone = inst.WAClient.Store.ID.User
+		whatsapp.SaveDeviceJID(inst.ID, *inst.WAClient.Store.ID)
+	}
+	inst.transition(StateConnected, stateChange{Phone: phone})
+}
+
+// setQR guarda o novo QR Code, transita para awaiting_qr e o publica via SSE e webhook
+func (inst *Instance) setQR(code string) {
+	inst.stateMu.Lock()
+	inst.lastQR = code
+	waiting := inst.status == StateAwaitingQR
+	inst.stateMu.Unlock()
+	if !waiting {
+		inst.transition(StateAwaitingQR, stateChange{})
+	}
+
+	inst.BroadcastSSE(`{"event":"qr","data":{"qrcode":"` + code + `"}}`)
+	go inst.sendWebhook("qrcode.updated", map[string]interface{}{"qrcode": code})
+}
+
+// MarkLoggedOut encerra a sessão: apaga o device store para que o próximo
+// Connect gere um novo QR Code e publica o evento logged_out
+func (inst *Instance) MarkLoggedOut(reason string) {
+	inst.cancel()
+	if inst.WAClient != nil {
+		inst.WAClient.Disconnect()
+		if inst.WAClient.Store.ID != nil {
+			inst.WAClient.Store.Delete(context.Background())
+		}
+	}
+	whatsapp.CloseContainer(inst.Container)
+	if err := whatsapp.DeleteSession(inst.ID); err != nil {
+		log.Printf("[LOGOUT] Instance %s: %v", inst.Name, err)
 	}
+
+	if inst.transition(StateLoggedOut, stateChange{Reason: reason}) {
+		go inst.sendWebhook("logged_out", map[string]interface{}{"reason": reason})
+	}
+}
+
+// publishState notifica a mudança de estado via SSE (status, mais os eventos
+// connected/disconnected usados pela web UI) e webhook (connection.update)
+func (inst *Instance) publishState(from State, info StatusInfo) {
+	sse, _ := json.Marshal(map[string]interface{}{"event": "status", "data": info})
+	inst.BroadcastSSE(string(sse))
+
+	switch {
+	case info.Status == StateConnected:
+		inst.BroadcastSSE(fmt.Sprintf(`{"event":"connected","data":{"phone":"%s","qrcode":""}}`, info.Phone))
+	case info.Status == StateDisconnected || info.Status.IsTerminal():
+		sse, _ = json.Marshal(map[string]interface{}{"event": "disconnected", "data": info})
+		inst.BroadcastSSE(string(sse))
+	}
+
+	data := map[string]interface{}{
+		"status":          info.Status,
+		"previous_status": from,
+		"phone":           info.Phone,
+	}
+	if info.Reason != "" {
+		data["reason"] = info.Reason
+	}
+	if info.BanExpiresAt != nil {
+		data["ban_expires_at"] = info.BanExpiresAt
+	}
+	go inst.sendWebhook("connection.update", data)
+}
+
+// PublishEvent envia um evento para os clientes SSE e para o webhook da instância
+func (inst *Instance) PublishEvent(event string, data map[string]interface{}) {
+	payload := map[string]interface{}{"event": event, "data": data}
 	jsonBytes, _ := json.Marshal(payload)
-	http.Post(inst.WebhookURL, "application/json", bytes.NewReader(jsonBytes))
+	inst.BroadcastSSE(string(jsonBytes))
+	go inst.sendWebhook(event, data)
 }
 
 func (inst *Instance) broadcastMessage(msgData map[string]interface{}) {
@@ -0,0 +1,94 @@
+package instance
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"wapi/internal/messages"
+	"wapi/internal/storage"
+	"wapi/internal/transcriber"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// needsDownload indica se a mídia da mensagem é baixada antes da publicação:
+// para guardar no storage (MEDIA_STORAGE) ou para transcrever o áudio
+func (inst *Instance) needsDownload(mc messageContent) bool {
+	if mc.Media == nil {
+		return false
+	}
+	return storage.Accepts(mc.Type, mc.Media.Size) || (mc.Type == "audio" && inst.TranscriptionEnabled)
+}
+
+// processMedia baixa a mídia, guarda a cópia no storage, transcreve áudios e
+// só então publica a mensagem. Se o download falhar a mensagem é publicada
+// sem os dados da mídia baixada.
+func (inst *Instance) processMedia(v *events.Message, msgData map[string]interface{}, rec *messages.Message) {
+	data, err := inst.WAClient.DownloadAny(context.Background(), v.Message)
+	if err != nil {
+		log.Printf("[MEDIA] Instance %s: erro ao baixar mídia %s: %v", inst.Name, rec.MessageID, err)
+		inst.broadcastMessage(msgData)
+		go inst.sendWebhook("messages.upsert", msgData)
+		return
+	}
+
+	if storage.Accepts(rec.Type, uint64(len(data))) {
+		if err := inst.storeMedia(rec, data); err != nil {
+			log.Printf("[MEDIA] Instance %s: %v", inst.Name, err)
+		} else {
+			msgData["media_url"] = rec.Media.URL
+			msgData["size"] = rec.Media.Size
+			msgData["sha256"] = rec.Media.SHA256
+		}
+	}
+
+	if rec.Type == "audio" && inst.TranscriptionEnabled {
+		text, _ := transcriber.Transcribe(data, "audio.ogg")
+		msgData["transcription"] = text
+		if text != "" {
+			if err := messages.SetTranscription(inst.ID, rec.ChatJID, rec.MessageID, text); err != nil {
+				log.Printf("[MESSAGE] Instance %s: %v", inst.Name, err)
+			}
+		}
+	}
+	inst.broadcastMessage(msgData)
+	go inst.sendWebhook("messages.upsert", msgData)
+}
+
+// storeMedia grava a mídia no storage e atualiza os metadados no histórico
+func (inst *Instance) storeMedia(rec *messages.Message, data []byte) error {
+	sum := sha256.Sum256(data)
+	media := *rec.Media
+	media.SHA256 = hex.EncodeToString(sum[:])
+	media.Size = uint64(len(data))
+	media.Key = storage.Key(inst.ID, media.SHA256, media.Mimetype)
+
+	url, err := storage.Global.Put(context.Background(), media.Key, data, media.Mimetype)
+	if err != nil {
+		return err
+	}
+	media.URL = url
+	rec.Media = &media
+	return messages.SetMedia(inst.ID, rec.ChatJID, rec.MessageID, rec.Media)
+}
+
+// DownloadMedia baixa de novo do WhatsApp a mídia de uma mensagem do
+// histórico, pelo direct path e media key gravados. Sem chatJID vale a
+// mensagem mais recente com o id.
+func (inst *Instance) DownloadMedia(ctx context.Context, chatJID, messageID string) ([]byte, *messages.Message, error) {
+	m, d, err := messages.FindMedia(inst.ID, chatJID, messageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if inst.WAClient == nil || !inst.WAClient.IsConnected() {
+		return nil, nil, ErrNotConnected
+	}
+	data, err := inst.WAClient.DownloadMediaWithPath(ctx, d.DirectPath, d.FileEncSHA256, d.FileSHA256, d.MediaKey,
+		int(d.FileLength), whatsmeow.MediaType(d.MediaType), "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, m, nil
+}
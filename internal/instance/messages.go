@@ -0,0 +1,64 @@
+package instance
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+	"time"
+	"wapi/internal/messages"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// chatJID normaliza o chat de uma mensagem: conversas endereçadas por LID
+// usam o número de telefone quando o mapeamento é conhecido, para que o
+// histórico de um contato fique num único chat
+func (inst *Instance) chatJID(chat types.JID) types.JID {
+	chat = chat.ToNonAD()
+	if chat.Server != types.HiddenUserServer || inst.Container == nil {
+		return chat
+	}
+	pn, err := inst.Container.LIDMap.GetPNForLID(context.Background(), chat)
+	if err != nil || pn.IsEmpty() {
+		return chat
+	}
+	return pn.ToNonAD()
+}
+
+// saveMessage grava a mensagem no histórico sem interromper o processamento
+func (inst *Instance) saveMessage(m *messages.Message) {
+	if err := messages.Save(m); err != nil {
+		log.Printf("[MESSAGE] Instance %s: %v", inst.Name, err)
+		return
+	}
+	if m.Direction == messages.Outbound {
+		inst.ApplyPendingReceipts(m.ChatJID, []string{m.MessageID})
+	}
+}
+
+// applyChange aplica ao histórico a edição ou a exclusão (revoke) de uma
+// mensagem, identificada pelo id em target_message_id
+func (inst *Instance) applyChange(chatJID string, mc messageContent, at time.Time) {
+	target, _ := mc.Fields["target_message_id"].(string)
+	if target == "" {
+		return
+	}
+	var err error
+	if mc.Type == typeEdit {
+		err = messages.Edit(inst.ID, chatJID, target, mc.Body, at)
+	} else {
+		err = messages.Revoke(inst.ID, chatJID, target, at)
+	}
+	if err != nil {
+		log.Printf("[MESSAGE] Instance %s: %v", inst.Name, err)
+	}
+}
+
+func mediaInfo(mimetype, filename string, size uint64, sha256 []byte) *messages.Media {
+	return &messages.Media{
+		Mimetype: mimetype,
+		Filename: filename,
+		Size:     size,
+		SHA256:   hex.EncodeToString(sha256),
+	}
+}
@@ -0,0 +1,97 @@
+package instance
+
+import (
+	"context"
+	"log"
+	"time"
+	"wapi/internal/messages"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Chats mais recentes cuja presença é assinada ao conectar
+const presenceSubscribeLimit = 100
+
+// subscribePresences anuncia a instância como disponível (o WhatsApp só envia
+// presença a quem está online) e assina a presença dos contatos dos chats
+// mais recentes. As assinaturas valem apenas para a conexão atual.
+func (inst *Instance) subscribePresences(client *whatsmeow.Client) {
+	inst.presenceMu.Lock()
+	inst.presenceSubs = make(map[types.JID]bool)
+	inst.presenceMu.Unlock()
+
+	if err := client.SendPresence(context.Background(), types.PresenceAvailable); err != nil {
+		log.Printf("[PRESENCE] Instance %s: erro ao enviar presença: %v", inst.Name, err)
+		return
+	}
+	chats, err := messages.ListChats(inst.ID, messages.ChatFilter{Limit: presenceSubscribeLimit})
+	if err != nil {
+		log.Printf("[PRESENCE] Instance %s: %v", inst.Name, err)
+		return
+	}
+	for _, c := range chats {
+		if c.IsGroup {
+			continue
+		}
+		if jid, err := types.ParseJID(c.JID); err == nil {
+			inst.subscribePresence(client, jid)
+		}
+	}
+}
+
+// subscribePresence assina a presença do contato uma vez por conexão
+func (inst *Instance) subscribePresence(client *whatsmeow.Client, jid types.JID) {
+	jid = jid.ToNonAD()
+	inst.presenceMu.Lock()
+	if inst.presenceSubs == nil || inst.presenceSubs[jid] {
+		inst.presenceMu.Unlock()
+		return
+	}
+	inst.presenceSubs[jid] = true
+	inst.presenceMu.Unlock()
+
+	if err := client.SubscribePresence(context.Background(), jid); err != nil {
+		log.Printf("[PRESENCE] Instance %s: erro ao assinar presença de %s: %v", inst.Name, jid, err)
+		inst.presenceMu.Lock()
+		delete(inst.presenceSubs, jid)
+		inst.presenceMu.Unlock()
+	}
+}
+
+// handlePresence publica presence.update: online/offline dos contatos
+// (available, unavailable) e digitando ou gravando áudio em um chat
+// (composing, recording, paused)
+func (inst *Instance) handlePresence(evt interface{}) {
+	switch v := evt.(type) {
+	case *events.Presence:
+		state := "available"
+		if v.Unavailable {
+			state = "unavailable"
+		}
+		data := map[string]interface{}{
+			"remote_jid": inst.chatJID(v.From).User,
+			"state":      state,
+		}
+		if !v.LastSeen.IsZero() {
+			data["last_seen"] = v.LastSeen.Format(time.RFC3339)
+		}
+		inst.PublishEvent("presence.update", data)
+	case *events.ChatPresence:
+		state := string(v.State)
+		if v.State == types.ChatPresenceComposing && v.Media == types.ChatPresenceMediaAudio {
+			state = "recording"
+		}
+		data := map[string]interface{}{
+			"remote_jid": inst.chatJID(v.Chat).User,
+			"chat_jid":   inst.chatJID(v.Chat).String(),
+			"is_group":   v.IsGroup,
+			"state":      state,
+		}
+		if v.IsGroup {
+			data["participant"] = inst.chatJID(v.Sender).User
+		}
+		inst.PublishEvent("presence.update", data)
+	}
+}
@@ -0,0 +1,102 @@
+package instance
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+	"wapi/internal/messages"
+	"wapi/internal/webhook"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// ReadMessages envia o recibo de leitura das mensagens recebidas (os tiques
+// azuis do remetente) e as marca como lidas no histórico. Os recibos são
+// agrupados por chat e remetente, que o WhatsApp exige nos grupos com o JID
+// original (LID, se a mensagem veio assim).
+func (inst *Instance) ReadMessages(ctx context.Context, list []messages.Message) error {
+	if len(list) == 0 {
+		return nil
+	}
+	if inst.WAClient == nil || !inst.WAClient.IsConnected() {
+		return ErrNotConnected
+	}
+
+	type receiptKey struct{ chat, sender string }
+	var order []receiptKey
+	groups := map[receiptKey][]types.MessageID{}
+	for _, m := range list {
+		sender := m.RawSenderJID
+		if sender == "" {
+			// Mensagens gravadas antes de o remetente original ser guardado
+			sender = m.SenderJID
+		}
+		key := receiptKey{m.ChatJID, sender}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], m.MessageID)
+	}
+
+	now := time.Now()
+	for _, key := range order {
+		chat, err := types.ParseJID(key.chat)
+		if err != nil {
+			continue
+		}
+		sender, _ := types.ParseJID(key.sender)
+		ids := groups[key]
+		if err := inst.WAClient.MarkRead(ctx, ids, now, chat, sender); err != nil {
+			return err
+		}
+		if err := messages.MarkRead(inst.ID, key.chat, ids, now); err != nil {
+			log.Printf("[READ] Instance %s: %v", inst.Name, err)
+		}
+	}
+	return nil
+}
+
+// WebhookDelivered marca como lida a mensagem recebida assim que o webhook
+// messages.upsert é confirmado, nas instâncias com auto_read ligado. Só contam
+// os destinos da própria instância (webhooks cadastrados e a URL legada): o
+// webhook global e os reenvios da dead-letter não marcam nada como lido.
+func (m *Manager) WebhookDelivered(d *webhook.Delivery) {
+	if d.Event != "messages.upsert" || d.Global || d.Replay {
+		return
+	}
+	inst, ok := m.Get(d.InstanceID)
+	if !ok || !inst.AutoRead {
+		return
+	}
+
+	var payload struct {
+		Data struct {
+			MessageID string `json:"messageId"`
+			ChatJID   string `json:"chat_jid"`
+			FromMe    bool   `json:"from_me"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(d.Payload, &payload); err != nil || payload.Data.MessageID == "" || payload.Data.ChatJID == "" || payload.Data.FromMe {
+		return
+	}
+
+	// Com vários destinos só a primeira confirmação marca a mensagem: as demais
+	// esperam aqui e já a encontram lida
+	inst.autoReadMu.Lock()
+	defer inst.autoReadMu.Unlock()
+	found, err := messages.FindInbound(inst.ID, payload.Data.ChatJID, []string{payload.Data.MessageID})
+	if err != nil {
+		log.Printf("[READ] Instance %s: %v", inst.Name, err)
+		return
+	}
+	unread := found[:0]
+	for _, msg := range found {
+		if msg.Status == messages.StatusReceived {
+			unread = append(unread, msg)
+		}
+	}
+	if err := inst.ReadMessages(context.Background(), unread); err != nil {
+		log.Printf("[READ] Instance %s: erro ao marcar %s como lida: %v", inst.Name, payload.Data.MessageID, err)
+	}
+}
@@ -0,0 +1,102 @@
+package instance
+
+import (
+	"log"
+	"time"
+	"wapi/internal/messages"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Recibos do destinatário que mudam o status das mensagens enviadas. Os demais
+// (sender, retry, read-self...) vêm dos nossos próprios aparelhos.
+var receiptStatus = map[types.ReceiptType]string{
+	types.ReceiptTypeDelivered: messages.StatusDelivered,
+	types.ReceiptTypeRead:      messages.StatusRead,
+	types.ReceiptTypePlayed:    messages.StatusPlayed,
+}
+
+// handleReceipt grava o novo status das mensagens enviadas e publica
+// messages.update só para as que mudaram
+func (inst *Instance) handleReceipt(v *events.Receipt) {
+	status, ok := receiptStatus[v.Type]
+	if !ok || v.IsFromMe || len(v.MessageIDs) == 0 {
+		return
+	}
+
+	participant := ""
+	if v.IsGroup {
+		participant = inst.chatJID(v.Sender).User
+	}
+	chat := inst.chatJID(v.Chat)
+
+	changed, err := messages.UpdateStatus(inst.ID, chat.String(), v.MessageIDs, status, v.Timestamp)
+	if err != nil {
+		log.Printf("[RECEIPT] Instance %s: %v", inst.Name, err)
+		return
+	}
+	if len(changed) > 0 {
+		inst.PublishMessageStatus(chat, changed, status, v.Timestamp, participant)
+	}
+	if len(changed) == len(v.MessageIDs) {
+		return
+	}
+
+	// O recibo pode chegar antes de a fila gravar a mensagem enviada: fica
+	// guardado e é aplicado quando ela for gravada (ver ApplyPendingReceipts)
+	missing := make([]string, 0, len(v.MessageIDs)-len(changed))
+	for _, id := range v.MessageIDs {
+		if !containsString(changed, id) {
+			missing = append(missing, id)
+		}
+	}
+	if err := messages.SavePendingReceipts(inst.ID, chat.String(), missing, status, v.Timestamp, participant); err != nil {
+		log.Printf("[RECEIPT] Instance %s: %v", inst.Name, err)
+		return
+	}
+	inst.ApplyPendingReceipts(chat.String(), missing)
+}
+
+// ApplyPendingReceipts aplica os recibos que chegaram antes das mensagens
+// enviadas serem gravadas e publica os que mudaram o status
+func (inst *Instance) ApplyPendingReceipts(chatJID string, messageIDs []string) {
+	applied, err := messages.ApplyPendingReceipts(inst.ID, chatJID, messageIDs)
+	if err != nil {
+		log.Printf("[RECEIPT] Instance %s: %v", inst.Name, err)
+		return
+	}
+	chat, err := types.ParseJID(chatJID)
+	if err != nil {
+		return
+	}
+	for _, r := range applied {
+		inst.PublishMessageStatus(chat, []string{r.MessageID}, r.Status, r.At, r.Participant)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// PublishMessageStatus publica a mudança de status de mensagens enviadas
+// (messages.update). participant identifica quem gerou o recibo em grupos.
+func (inst *Instance) PublishMessageStatus(chat types.JID, messageIDs []string, status string, ts time.Time, participant string) {
+	data := map[string]interface{}{
+		"message_ids": messageIDs,
+		"status":      status,
+		"remote_jid":  chat.User,
+		"chat_jid":    chat.String(),
+		"is_group":    chat.Server == types.GroupServer,
+		"timestamp":   ts.Format(time.RFC3339),
+	}
+	if participant != "" {
+		data["participant"] = participant
+	}
+	inst.PublishEvent("messages.update", data)
+}
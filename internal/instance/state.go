@@ -0,0 +1,217 @@
+package instance
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"wapi/store/postgres"
+)
+
+// State é o estado de conexão da instância
+type State string
+
+const (
+	StateCreated      State = "created"
+	StateConnecting   State = "connecting"
+	StateAwaitingQR   State = "awaiting_qr"
+	StatePairing      State = "pairing"
+	StateConnected    State = "connected"
+	StateDisconnected State = "disconnected"
+	StateLoggedOut    State = "logged_out"
+	StateReplaced     State = "replaced"
+	StateBanned       State = "banned"
+)
+
+// ReasonExported marca a instância desconectada por ter sido exportada para
+// outro servidor: ela não é reconectada na inicialização
+const ReasonExported = "exported"
+
+// transitions lista, para cada estado, os estados que podem sucedê-lo.
+// Estados terminais (logged_out, replaced, banned) só saem com um novo Connect.
+var transitions = map[State][]State{
+	StateCreated:      {StateConnecting},
+	StateConnecting:   {StateAwaitingQR, StatePairing, StateConnected, StateDisconnected, StateLoggedOut, StateReplaced, StateBanned},
+	StateAwaitingQR:   {StateConnecting, StatePairing, StateConnected, StateDisconnected, StateLoggedOut},
+	StatePairing:      {StateConnecting, StateAwaitingQR, StateConnected, StateDisconnected, StateLoggedOut},
+	StateConnected:    {StateConnecting, StateDisconnected, StateLoggedOut, StateReplaced, StateBanned},
+	StateDisconnected: {StateConnecting, StateConnected, StateLoggedOut, StateReplaced, StateBanned},
+	StateLoggedOut:    {StateConnecting},
+	StateReplaced:     {StateConnecting},
+	StateBanned:       {StateConnecting},
+}
+
+// CanTransition indica se a mudança para o estado to é permitida
+func (s State) CanTransition(to State) bool {
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTerminal indica se a instância parou por um motivo que exige ação
+// (novo pareamento, liberação do número ou fim do banimento)
+func (s State) IsTerminal() bool {
+	return s == StateLoggedOut || s == StateReplaced || s == StateBanned
+}
+
+// parseState converte o status salvo no banco. Estados transitórios viram
+// disconnected, já que ao carregar do banco nenhuma conexão está ativa.
+func parseState(status string) State {
+	switch s := State(status); s {
+	case StateCreated, StateDisconnected, StateLoggedOut, StateReplaced, StateBanned:
+		return s
+	}
+	return StateDisconnected
+}
+
+// StatusInfo é a visão do estado usada pela API REST, SSE, webhooks e web UI
+type StatusInfo struct {
+	Status       State          `json:"status"`
+	Phone        string         `json:"phone"`
+	Reason       string         `json:"reason,omitempty"`
+	BanExpiresAt *time.Time     `json:"ban_expires_at,omitempty"`
+	Reconnect    *ReconnectInfo `json:"reconnect,omitempty"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+}
+
+// stateChange são os dados que acompanham uma transição
+type stateChange struct {
+	Reason       string
+	Phone        string
+	BanExpiresAt time.Time
+}
+
+// Transition é uma mudança de estado registrada no histórico
+type Transition struct {
+	ID        int64     `json:"id"`
+	From      State     `json:"from"`
+	To        State     `json:"to"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// State retorna o estado atual da instância
+func (inst *Instance) State() State {
+	inst.stateMu.Lock()
+	defer inst.stateMu.Unlock()
+	return inst.status
+}
+
+// Phone retorna o número conectado (vazio se não houver sessão ativa)
+func (inst *Instance) Phone() string {
+	inst.stateMu.Lock()
+	defer inst.stateMu.Unlock()
+	return inst.phone
+}
+
+// LastQR retorna o último QR Code gerado enquanto aguarda pareamento
+func (inst *Instance) LastQR() string {
+	inst.stateMu.Lock()
+	defer inst.stateMu.Unlock()
+	return inst.lastQR
+}
+
+// StatusInfo retorna uma cópia consistente do estado atual
+func (inst *Instance) StatusInfo() StatusInfo {
+	inst.stateMu.Lock()
+	defer inst.stateMu.Unlock()
+	return inst.statusInfoLocked()
+}
+
+func (inst *Instance) statusInfoLocked() StatusInfo {
+	info := StatusInfo{
+		Status:    inst.status,
+		Phone:     inst.phone,
+		Reason:    inst.statusReason,
+		Reconnect: inst.reconnectInfoLocked(),
+		UpdatedAt: inst.stateChangedAt,
+	}
+	if !inst.banExpiresAt.IsZero() {
+		expires := inst.banExpiresAt
+		info.BanExpiresAt = &expires
+	}
+	return info
+}
+
+// RestoreState carrega o estado salvo no banco ao iniciar o servidor, sem
+// validar a transição nem publicar eventos
+func (inst *Instance) RestoreState(status, phone, reason string, banExpiresAt time.Time) {
+	inst.stateMu.Lock()
+	defer inst.stateMu.Unlock()
+	inst.status = parseState(status)
+	inst.statusReason = reason
+	inst.banExpiresAt = banExpiresAt
+	if inst.status == StateReplaced || inst.status == StateBanned {
+		inst.phone = phone
+	}
+	if string(inst.status) != status {
+		inst.statusReason = ""
+	}
+}
+
+// transition aplica a mudança de estado se ela for permitida a partir do estado
+// atual, persiste no banco, registra no histórico e publica via SSE e webhook.
+// Retorna false quando a transição é ignorada.
+func (inst *Instance) transition(to State, change stateChange) bool {
+	inst.stateMu.Lock()
+	from := inst.status
+	if !from.CanTransition(to) {
+		inst.stateMu.Unlock()
+		if from != to {
+			log.Printf("[STATE] Instance %s: transição %s → %s ignorada", inst.Name, from, to)
+		}
+		return false
+	}
+
+	inst.status = to
+	inst.statusReason = change.Reason
+	inst.banExpiresAt = change.BanExpiresAt
+	inst.stateChangedAt = time.Now()
+	switch to {
+	case StateConnected:
+		inst.phone = change.Phone
+		inst.lastQR = ""
+	case StateDisconnected, StateLoggedOut:
+		inst.phone = ""
+		inst.lastQR = ""
+	}
+	info := inst.statusInfoLocked()
+	inst.saveStatusLocked()
+	inst.stateMu.Unlock()
+
+	log.Printf("[STATE] Instance %s: %s → %s %s", inst.Name, from, to, change.Reason)
+	postgres.DB.Exec(
+		`INSERT INTO instance_state_transitions (instance_id, from_status, to_status, reason) VALUES ($1, $2, $3, $4)`,
+		inst.ID, from, to, change.Reason,
+	)
+	inst.publishState(from, info)
+	return true
+}
+
+// ListTransitions retorna as mudanças de estado mais recentes da instância
+func ListTransitions(instanceID string, limit int) ([]Transition, error) {
+	rows, err := postgres.DB.Query(
+		`SELECT id, from_status, to_status, reason, created_at FROM instance_state_transitions
+		WHERE instance_id = $1 ORDER BY id DESC LIMIT $2`,
+		instanceID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao listar histórico de estados: %w", err)
+	}
+	defer rows.Close()
+
+	history := []Transition{}
+	for rows.Next() {
+		var t Transition
+		if err := rows.Scan(&t.ID, &t.From, &t.To, &t.Reason, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("erro ao listar histórico de estados: %w", err)
+		}
+		history = append(history, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao listar histórico de estados: %w", err)
+	}
+	return history, nil
+}
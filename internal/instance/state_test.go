@@ -0,0 +1,99 @@
+package instance
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to State
+		ok       bool
+	}{
+		{StateCreated, StateConnecting, true},
+		{StateCreated, StateConnected, false},
+		{StateConnecting, StateAwaitingQR, true},
+		{StateConnecting, StateConnected, true},
+		{StateConnecting, StateBanned, true},
+		{StateAwaitingQR, StatePairing, true},
+		{StateAwaitingQR, StateBanned, false},
+		{StatePairing, StateConnected, true},
+		{StatePairing, StateReplaced, false},
+		{StateConnected, StateDisconnected, true},
+		{StateConnected, StateAwaitingQR, false},
+		{StateConnected, StateCreated, false},
+		{StateDisconnected, StateConnected, true},
+		{StateDisconnected, StateAwaitingQR, false},
+		{StateLoggedOut, StateConnecting, true},
+		{StateLoggedOut, StateConnected, false},
+		{StateReplaced, StateConnecting, true},
+		{StateReplaced, StateDisconnected, false},
+		{StateBanned, StateConnecting, true},
+		{StateBanned, StateConnected, false},
+		{State("desconhecido"), StateConnecting, false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.CanTransition(tt.to); got != tt.ok {
+			t.Errorf("%s -> %s: got %v, want %v", tt.from, tt.to, got, tt.ok)
+		}
+	}
+}
+
+func TestTransitionsCoverEveryState(t *testing.T) {
+	states := []State{StateCreated, StateConnecting, StateAwaitingQR, StatePairing, StateConnected,
+		StateDisconnected, StateLoggedOut, StateReplaced, StateBanned}
+	for _, s := range states {
+		if len(transitions[s]) == 0 {
+			t.Errorf("%s sem transições de saída", s)
+		}
+		// Todo estado precisa poder voltar a conectar
+		if s != StateConnecting && !s.CanTransition(StateConnecting) {
+			t.Errorf("%s não pode ir para connecting", s)
+		}
+		if s.CanTransition(s) {
+			t.Errorf("%s transita para si mesmo", s)
+		}
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	tests := []struct {
+		state    State
+		terminal bool
+	}{
+		{StateCreated, false},
+		{StateConnecting, false},
+		{StateConnected, false},
+		{StateDisconnected, false},
+		{StateLoggedOut, true},
+		{StateReplaced, true},
+		{StateBanned, true},
+	}
+	for _, tt := range tests {
+		if got := tt.state.IsTerminal(); got != tt.terminal {
+			t.Errorf("%s: got %v, want %v", tt.state, got, tt.terminal)
+		}
+	}
+}
+
+func TestParseState(t *testing.T) {
+	tests := []struct {
+		status string
+		want   State
+	}{
+		{"created", StateCreated},
+		{"disconnected", StateDisconnected},
+		{"logged_out", StateLoggedOut},
+		{"replaced", StateReplaced},
+		{"banned", StateBanned},
+		// Estados transitórios não sobrevivem a um reinício
+		{"connecting", StateDisconnected},
+		{"awaiting_qr", StateDisconnected},
+		{"pairing", StateDisconnected},
+		{"connected", StateDisconnected},
+		{"", StateDisconnected},
+		{"qualquer", StateDisconnected},
+	}
+	for _, tt := range tests {
+		if got := parseState(tt.status); got != tt.want {
+			t.Errorf("parseState(%q) = %s, want %s", tt.status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+	"wapi/config"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	// Intervalo da verificação de saúde da conexão
+	healthInterval = 20 * time.Second
+	// Falhas seguidas de keepalive que caracterizam uma conexão morta
+	keepAliveMaxErrors = 3
+)
+
+// ReconnectInfo descreve as tentativas de reconexão em andamento
+type ReconnectInfo struct {
+	Attempts      int        `json:"attempts"`
+	MaxAttempts   int        `json:"max_attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	// SlowRetry indica que as tentativas rápidas se esgotaram e a instância
+	// segue tentando a cada RECONNECT_MAX_SECONDS
+	SlowRetry bool `json:"slow_retry"`
+}
+
+// requestReconnect acorda o supervisor para reconectar a instância
+func (inst *Instance) requestReconnect() {
+	select {
+	case inst.reconnectCh <- struct{}{}:
+	default:
+	}
+}
+
+// supervise acompanha a conexão do client enquanto ctx estiver ativo: envia
+// presença, detecta conexões mortas e reconecta com backoff exponencial.
+// Substitui o auto-reconnect do whatsmeow, que fica desligado.
+func (inst *Instance) supervise(ctx context.Context, client *whatsmeow.Client) {
+	// Descarta pedidos de reconexão de um client anterior
+	select {
+	case <-inst.reconnectCh:
+	default:
+	}
+
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-inst.reconnectCh:
+			inst.reconnect(ctx, client)
+		case <-ticker.C:
+			state := inst.State()
+			if client.IsConnected() {
+				client.SendPresence(context.Background(), types.PresenceAvailable)
+				if state != StateConnected && client.IsLoggedIn() {
+					log.Printf("[SUPERVISOR] Instance %s reconnected", inst.Name)
+					inst.markConnected()
+				}
+			} else if state == StateConnected {
+				log.Printf("[SUPERVISOR] Instance %s perdeu a conexão", inst.Name)
+				inst.transition(StateDisconnected, stateChange{Reason: "conexão perdida"})
+				inst.reconnect(ctx, client)
+			}
+		}
+	}
+}
+
+// reconnect tenta reconectar até conseguir ou até a sessão deixar de ser
+// válida. Depois de RECONNECT_MAX_ATTEMPTS tentativas com backoff, segue
+// tentando a cada RECONNECT_MAX_SECONDS. Nunca faz logout: uma sessão válida
+// continua salva e pode ser reconectada a qualquer momento com POST /connect.
+func (inst *Instance) reconnect(ctx context.Context, client *whatsmeow.Client) {
+	maxAttempts := config.App.ReconnectMaxAttempts
+	for {
+		if client.IsConnected() || client.Store.ID == nil || inst.State().IsTerminal() {
+			return
+		}
+
+		inst.stateMu.Lock()
+		inst.reconnectAttempts++
+		attempt := inst.reconnectAttempts
+		var delay time.Duration
+		if maxAttempts > 0 && attempt > maxAttempts {
+			if !inst.reconnectSlow {
+				log.Printf("[SUPERVISOR] Instance %s: %d tentativa(s) sem sucesso, tentando a cada %ds", inst.Name, maxAttempts, config.App.ReconnectMaxSeconds)
+			}
+			inst.reconnectSlow = true
+			delay = withJitter(time.Duration(config.App.ReconnectMaxSeconds) * time.Second)
+		} else {
+			delay = reconnectDelay(attempt)
+		}
+		inst.reconnectNextAt = time.Now().Add(delay)
+		inst.stateMu.Unlock()
+
+		log.Printf("[SUPERVISOR] Instance %s: tentativa de reconexão %d em %s", inst.Name, attempt, delay.Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		inst.transition(StateConnecting, stateChange{Reason: fmt.Sprintf("reconexão, tentativa %d", attempt)})
+		err := client.Connect()
+		if err == nil || errors.Is(err, whatsmeow.ErrAlreadyConnected) {
+			// O evento Connected confirma o login e zera as tentativas
+			return
+		}
+
+		log.Printf("[SUPERVISOR] Instance %s: tentativa %d falhou: %v", inst.Name, attempt, err)
+		inst.stateMu.Lock()
+		inst.reconnectLastError = err.Error()
+		inst.stateMu.Unlock()
+		inst.transition(StateDisconnected, stateChange{Reason: err.Error()})
+	}
+}
+
+// resetReconnect zera as tentativas após uma conexão bem-sucedida ou manual
+func (inst *Instance) resetReconnect() {
+	inst.stateMu.Lock()
+	defer inst.stateMu.Unlock()
+	inst.reconnectAttempts = 0
+	inst.reconnectNextAt = time.Time{}
+	inst.reconnectLastError = ""
+	inst.reconnectSlow = false
+}
+
+// reconnectInfoLocked retorna nil quando não há reconexão em andamento
+func (inst *Instance) reconnectInfoLocked() *ReconnectInfo {
+	if inst.reconnectAttempts == 0 {
+		return nil
+	}
+	info := &ReconnectInfo{
+		Attempts:    inst.reconnectAttempts,
+		MaxAttempts: config.App.ReconnectMaxAttempts,
+		LastError:   inst.reconnectLastError,
+		SlowRetry:   inst.reconnectSlow,
+	}
+	if !inst.reconnectNextAt.IsZero() {
+		next := inst.reconnectNextAt
+		info.NextAttemptAt = &next
+	}
+	return info
+}
+
+// reconnectDelay calcula base * 2^(tentativa-1), limitado ao máximo
+// configurado, com jitter de ±20% para as instâncias não reconectarem juntas
+func reconnectDelay(attempt int) time.Duration {
+	base := time.Duration(config.App.ReconnectBaseSeconds) * time.Second
+	max := time.Duration(config.App.ReconnectMaxSeconds) * time.Second
+	d := base << (attempt - 1)
+	if d > max || d <= 0 {
+		d = max
+	}
+	return withJitter(d)
+}
+
+// withJitter varia d em ±20%
+func withJitter(d time.Duration) time.Duration {
+	jitter := time.Duration(rand.Int63n(int64(d)/5*2+1)) - d/5
+	return d + jitter
+}
+
+// ScheduleBanExpiry reconecta a instância quando o banimento temporário
+// expirar, se ela ainda estiver banida
+func (inst *Instance) ScheduleBanExpiry(expiresAt time.Time) {
+	inst.stateMu.Lock()
+	defer inst.stateMu.Unlock()
+	if inst.banTimer != nil {
+		inst.banTimer.Stop()
+	}
+	inst.banTimer = time.AfterFunc(time.Until(expiresAt), func() {
+		// Instância removida ou já reconectada manualmente
+		if current, ok := Global.Get(inst.ID); !ok || current != inst || inst.State() != StateBanned {
+			return
+		}
+		log.Printf("[SUPERVISOR] Instance %s: banimento expirado, reconectando", inst.Name)
+		if err := inst.Connect(); err != nil {
+			log.Printf("[SUPERVISOR] Instance %s: erro ao reconectar após o banimento: %v", inst.Name, err)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package instance
+
+import (
+	"testing"
+	"time"
+	"wapi/config"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	saved := config.App
+	defer func() { config.App = saved }()
+	config.App.ReconnectBaseSeconds = 2
+	config.App.ReconnectMaxSeconds = 300
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{7, 128 * time.Second},
+		{8, 256 * time.Second},
+		// Acima do máximo fica no teto
+		{9, 300 * time.Second},
+		{20, 300 * time.Second},
+		// Deslocamentos que estouram o int64 também ficam no teto
+		{64, 300 * time.Second},
+		{100, 300 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 50; i++ {
+			got := reconnectDelay(tt.attempt)
+			if got < tt.want*8/10 || got > tt.want*12/10 {
+				t.Fatalf("reconnectDelay(%d) = %s, want %s ±20%%", tt.attempt, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestWithJitter(t *testing.T) {
+	tests := []time.Duration{
+		time.Second,
+		30 * time.Second,
+		5 * time.Minute,
+		time.Hour,
+	}
+	for _, d := range tests {
+		min, max := d, d
+		for i := 0; i < 500; i++ {
+			got := withJitter(d)
+			if got < d*8/10 || got > d*12/10 {
+				t.Fatalf("withJitter(%s) = %s, fora de ±20%%", d, got)
+			}
+			if got < min {
+				min = got
+			}
+			if got > max {
+				max = got
+			}
+		}
+		// O atraso precisa variar, senão as instâncias reconectam juntas
+		if min == max {
+			t.Errorf("withJitter(%s) sempre retornou %s", d, min)
+		}
+	}
+}
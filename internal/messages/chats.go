@@ -0,0 +1,261 @@
+package messages
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"wapi/store/postgres"
+)
+
+// Tamanho máximo da prévia da última mensagem
+const previewLength = 100
+
+// Chat é uma conversa da instância, com a última mensagem e os contadores
+type Chat struct {
+	JID            string       `json:"jid"`
+	Name           string       `json:"name"`
+	IsGroup        bool         `json:"is_group"`
+	LastMessage    *LastMessage `json:"last_message,omitempty"`
+	UnreadCount    int          `json:"unread_count"`
+	Archived       bool         `json:"archived"`
+	Pinned         bool         `json:"pinned"`
+	Muted          bool         `json:"muted"`
+	MutedUntil     *time.Time   `json:"muted_until,omitempty"`
+	LastActivityAt *time.Time   `json:"last_activity_at,omitempty"`
+}
+
+type LastMessage struct {
+	MessageID string    `json:"message_id"`
+	Type      string    `json:"type"`
+	Preview   string    `json:"preview"`
+	FromMe    bool      `json:"from_me"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ChatUpdate altera os campos não nulos do chat, criando-o se preciso
+type ChatUpdate struct {
+	Name        *string
+	IsGroup     bool
+	Archived    *bool
+	Pinned      *bool
+	Muted       *bool
+	MutedUntil  *time.Time
+	UnreadCount *int
+	// Read zera o contador; false marca o chat como não lido
+	Read *bool
+	// LastActivityAt só avança a última atividade
+	LastActivityAt *time.Time
+}
+
+// ChatFilter limita a listagem dos chats
+type ChatFilter struct {
+	Archived *bool
+	Limit    int
+	Offset   int
+}
+
+// typeLabels descreve as mensagens sem texto na prévia do chat e no webhook
+var typeLabels = map[string]string{
+	"image":         "[imagem]",
+	"audio":         "[áudio]",
+	"video":         "[vídeo]",
+	"document":      "[documento]",
+	"sticker":       "[figurinha]",
+	"location":      "[localização]",
+	"live_location": "[localização em tempo real]",
+	"contact":       "[contato]",
+	"contacts":      "[contatos]",
+	"poll":          "[enquete]",
+	"reaction":      "[reação]",
+	"revoke":        "[mensagem apagada]",
+}
+
+// TypeLabel retorna a descrição de uma mensagem do tipo, usada quando ela não tem texto
+func TypeLabel(msgType string) string {
+	if label, ok := typeLabels[msgType]; ok {
+		return label
+	}
+	return "[mensagem]"
+}
+
+func preview(m *Message) string {
+	body := strings.TrimSpace(m.Body)
+	if body == "" {
+		return TypeLabel(m.Type)
+	}
+	if utf8.RuneCountInString(body) > previewLength {
+		return string([]rune(body)[:previewLength]) + "…"
+	}
+	return body
+}
+
+// touchPreview troca a prévia do chat quando a mensagem alterada é a última
+func touchPreview(instanceID, chatJID, messageID, text string) error {
+	_, err := postgres.DB.Exec(
+		`UPDATE chats SET last_message_preview = $1, updated_at = NOW()
+		WHERE instance_id = $2 AND chat_jid = $3 AND last_message_id = $4`,
+		text, instanceID, chatJID, messageID,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao atualizar chat: %w", err)
+	}
+	return nil
+}
+
+// touchChat atualiza o chat com uma mensagem nova. A última mensagem só é
+// trocada por uma mais recente; unread soma a mensagem ao contador de não
+// lidas e reset zera o contador (mensagem enviada).
+func touchChat(m *Message, name string, unread, reset bool) error {
+	fromMe := m.Direction == Outbound
+	_, err := postgres.DB.Exec(
+		`INSERT INTO chats (instance_id, chat_jid, name, is_group, last_message_id, last_message_type,
+			last_message_preview, last_message_from_me, last_message_at, last_activity_at, unread_count)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9, CASE WHEN $10 THEN 1 ELSE 0 END)
+		ON CONFLICT (instance_id, chat_jid) DO UPDATE SET
+			name = CASE WHEN chats.name = '' THEN EXCLUDED.name ELSE chats.name END,
+			last_message_id = CASE WHEN chats.last_message_at IS NULL OR EXCLUDED.last_message_at >= chats.last_message_at
+				THEN EXCLUDED.last_message_id ELSE chats.last_message_id END,
+			last_message_type = CASE WHEN chats.last_message_at IS NULL OR EXCLUDED.last_message_at >= chats.last_message_at
+				THEN EXCLUDED.last_message_type ELSE chats.last_message_type END,
+			last_message_preview = CASE WHEN chats.last_message_at IS NULL OR EXCLUDED.last_message_at >= chats.last_message_at
+				THEN EXCLUDED.last_message_preview ELSE chats.last_message_preview END,
+			last_message_from_me = CASE WHEN chats.last_message_at IS NULL OR EXCLUDED.last_message_at >= chats.last_message_at
+				THEN EXCLUDED.last_message_from_me ELSE chats.last_message_from_me END,
+			last_message_at = GREATEST(chats.last_message_at, EXCLUDED.last_message_at),
+			last_activity_at = GREATEST(chats.last_activity_at, EXCLUDED.last_activity_at),
+			unread_count = CASE WHEN $10 THEN chats.unread_count + 1 WHEN $11 THEN 0 ELSE chats.unread_count END,
+			updated_at = NOW()`,
+		m.InstanceID, m.ChatJID, name, strings.HasSuffix(m.ChatJID, "@g.us"), m.MessageID, m.Type,
+		preview(m), fromMe, m.Timestamp.UTC(), unread, reset,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao atualizar chat: %w", err)
+	}
+	return nil
+}
+
+// UpdateChat aplica as alterações vindas de eventos de app state, grupos e
+// sincronização de histórico
+func UpdateChat(instanceID, chatJID string, u ChatUpdate) error {
+	isGroup := u.IsGroup || strings.HasSuffix(chatJID, "@g.us")
+	_, err := postgres.DB.Exec(
+		`INSERT INTO chats (instance_id, chat_jid, is_group) VALUES ($1, $2, $3)
+		ON CONFLICT (instance_id, chat_jid) DO NOTHING`,
+		instanceID, chatJID, isGroup,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao atualizar chat: %w", err)
+	}
+
+	sets := []string{"updated_at = NOW()"}
+	args := []interface{}{instanceID, chatJID}
+	set := func(expr string, arg interface{}) {
+		args = append(args, arg)
+		sets = append(sets, strings.ReplaceAll(expr, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if u.Name != nil && *u.Name != "" {
+		set("name = ?", *u.Name)
+	}
+	if u.Archived != nil {
+		set("archived = ?", *u.Archived)
+	}
+	if u.Pinned != nil {
+		set("pinned = ?", *u.Pinned)
+	}
+	if u.Muted != nil {
+		set("muted = ?", *u.Muted)
+		var until sql.NullTime
+		if u.MutedUntil != nil {
+			until = sql.NullTime{Time: u.MutedUntil.UTC(), Valid: true}
+		}
+		set("muted_until = ?", until)
+	}
+	if u.UnreadCount != nil {
+		set("unread_count = ?", *u.UnreadCount)
+	}
+	if u.Read != nil {
+		if *u.Read {
+			sets = append(sets, "unread_count = 0")
+		} else {
+			sets = append(sets, "unread_count = GREATEST(unread_count, 1)")
+		}
+	}
+	if u.LastActivityAt != nil {
+		set("last_activity_at = GREATEST(last_activity_at, ?)", u.LastActivityAt.UTC())
+	}
+
+	_, err = postgres.DB.Exec(
+		`UPDATE chats SET `+strings.Join(sets, ", ")+` WHERE instance_id = $1 AND chat_jid = $2`,
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao atualizar chat: %w", err)
+	}
+	return nil
+}
+
+// ChatName retorna o nome conhecido do chat (vazio se não houver)
+func ChatName(instanceID, chatJID string) string {
+	var name string
+	postgres.DB.QueryRow(
+		`SELECT name FROM chats WHERE instance_id = $1 AND chat_jid = $2`, instanceID, chatJID,
+	).Scan(&name)
+	return name
+}
+
+// ListChats lista os chats da instância pela última atividade, mais recente primeiro
+func ListChats(instanceID string, f ChatFilter) ([]Chat, error) {
+	var archived sql.NullBool
+	if f.Archived != nil {
+		archived = sql.NullBool{Bool: *f.Archived, Valid: true}
+	}
+	rows, err := postgres.DB.Query(
+		`SELECT chat_jid, name, is_group, last_message_id, last_message_type, last_message_preview,
+			last_message_from_me, last_message_at, unread_count, archived, pinned, muted, muted_until, last_activity_at
+		FROM chats
+		WHERE instance_id = $1 AND ($2::boolean IS NULL OR archived = $2)
+		ORDER BY last_activity_at DESC NULLS LAST, chat_jid
+		LIMIT $3 OFFSET $4`,
+		instanceID, archived, f.Limit, f.Offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao listar chats: %w", err)
+	}
+	defer rows.Close()
+
+	chats := []Chat{}
+	now := time.Now()
+	for rows.Next() {
+		var c Chat
+		var last LastMessage
+		var lastAt, mutedUntil, activity sql.NullTime
+		err := rows.Scan(&c.JID, &c.Name, &c.IsGroup, &last.MessageID, &last.Type, &last.Preview,
+			&last.FromMe, &lastAt, &c.UnreadCount, &c.Archived, &c.Pinned, &c.Muted, &mutedUntil, &activity)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao listar chats: %w", err)
+		}
+		if lastAt.Valid {
+			last.Timestamp = lastAt.Time
+			c.LastMessage = &last
+		}
+		if mutedUntil.Valid {
+			c.MutedUntil = &mutedUntil.Time
+			// Silenciamento temporário que já expirou
+			if c.Muted && mutedUntil.Time.Before(now) {
+				c.Muted = false
+				c.MutedUntil = nil
+			}
+		}
+		if activity.Valid {
+			c.LastActivityAt = &activity.Time
+		}
+		chats = append(chats, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao listar chats: %w", err)
+	}
+	return chats, nil
+}
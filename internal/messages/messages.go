@@ -0,0 +1,673 @@
+// Package messages guarda as mensagens recebidas e enviadas pelas instâncias
+package messages
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"wapi/store/postgres"
+
+	"github.com/lib/pq"
+)
+
+// Direção da mensagem
+const (
+	Inbound  = "inbound"
+	Outbound = "outbound"
+)
+
+// Status de uma mensagem. As enviadas começam em server_ack (aceita pelo
+// servidor) e avançam conforme os recibos do destinatário chegam.
+const (
+	StatusReceived  = "received"
+	StatusServerAck = "server_ack"
+	StatusDelivered = "delivered"
+	StatusRead      = "read"
+	StatusPlayed    = "played"
+)
+
+// Ordem dos status das mensagens enviadas: um recibo atrasado não volta o status
+var statusRank = map[string]int{
+	StatusServerAck: 1,
+	StatusDelivered: 2,
+	StatusRead:      3,
+	StatusPlayed:    4,
+}
+
+var (
+	ErrInvalidCursor = errors.New("cursor inválido")
+	ErrNotFound      = errors.New("mensagem não encontrada")
+	ErrNoMedia       = errors.New("mensagem sem mídia para baixar")
+)
+
+type Message struct {
+	ID            int64      `json:"-"`
+	InstanceID    string     `json:"instance_id"`
+	MessageID     string     `json:"message_id"`
+	ChatJID       string     `json:"chat_jid"`
+	SenderJID     string     `json:"sender_jid"`
+	PushName      string     `json:"push_name,omitempty"`
+	Direction     string     `json:"direction"`
+	Type          string     `json:"type"`
+	Body          string     `json:"body"`
+	Transcription string     `json:"transcription,omitempty"`
+	Media         *Media     `json:"media,omitempty"`
+	Status        string     `json:"status"`
+	StatusAt      *time.Time `json:"status_at,omitempty"`
+	EditedAt      *time.Time `json:"edited_at,omitempty"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	Timestamp     time.Time  `json:"timestamp"`
+	CreatedAt     time.Time  `json:"created_at"`
+
+	// RawSenderJID é o remetente como veio do WhatsApp (um LID em grupos
+	// endereçados por LID), exigido nos recibos de leitura
+	RawSenderJID string `json:"-"`
+
+	// Dados para baixar a mídia de novo, gravados à parte (ver FindMedia)
+	Download *MediaDownload `json:"-"`
+}
+
+// Media são os metadados do anexo da mensagem
+type Media struct {
+	Mimetype string `json:"mimetype"`
+	Filename string `json:"filename,omitempty"`
+	Size     uint64 `json:"size"`
+	SHA256   string `json:"sha256,omitempty"`
+	// Key e URL indicam a cópia guardada no storage (ver package storage)
+	Key string `json:"key,omitempty"`
+	URL string `json:"url,omitempty"`
+}
+
+// MediaDownload guarda o necessário para baixar a mídia de novo do WhatsApp.
+// Não aparece no histórico: a media key decifra o arquivo.
+type MediaDownload struct {
+	DirectPath    string `json:"direct_path"`
+	MediaKey      []byte `json:"media_key"`
+	FileSHA256    []byte `json:"file_sha256"`
+	FileEncSHA256 []byte `json:"file_enc_sha256"`
+	FileLength    uint64 `json:"file_length"`
+	MediaType     string `json:"media_type"`
+}
+
+// Filter limita a listagem das mensagens de um chat
+type Filter struct {
+	Limit  int
+	Cursor string
+	Since  time.Time
+	Until  time.Time
+}
+
+const messageColumns = `id, instance_id, message_id, chat_jid, sender_jid, push_name, direction, type, body,
+	transcription, media, status, status_at, edited_at, revoked_at, timestamp, created_at, raw_sender_jid`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMessage(row rowScanner) (*Message, error) {
+	var m Message
+	var media []byte
+	var statusAt, editedAt, revokedAt sql.NullTime
+	err := row.Scan(&m.ID, &m.InstanceID, &m.MessageID, &m.ChatJID, &m.SenderJID, &m.PushName, &m.Direction,
+		&m.Type, &m.Body, &m.Transcription, &media, &m.Status, &statusAt, &editedAt, &revokedAt,
+		&m.Timestamp, &m.CreatedAt, &m.RawSenderJID)
+	if err != nil {
+		return nil, err
+	}
+	if statusAt.Valid {
+		m.StatusAt = &statusAt.Time
+	}
+	if editedAt.Valid {
+		m.EditedAt = &editedAt.Time
+	}
+	if revokedAt.Valid {
+		m.RevokedAt = &revokedAt.Time
+	}
+	if len(media) > 0 {
+		m.Media = &Media{}
+		if err := json.Unmarshal(media, m.Media); err != nil {
+			return nil, err
+		}
+	}
+	return &m, nil
+}
+
+func scanMessages(rows *sql.Rows) ([]Message, error) {
+	defer rows.Close()
+	list := []Message{}
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao buscar mensagens: %w", err)
+		}
+		list = append(list, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao buscar mensagens: %w", err)
+	}
+	return list, nil
+}
+
+// Save grava a mensagem e atualiza o chat. Uma mensagem já gravada (mesmo
+// chat e id) é mantida. Mensagens recebidas contam como não lidas.
+func Save(m *Message) error {
+	return save(m, false)
+}
+
+// SaveHistory grava uma mensagem importada da sincronização de histórico. O
+// contador de não lidas não muda: ele vem do estado da conversa.
+func SaveHistory(m *Message) error {
+	return save(m, true)
+}
+
+func save(m *Message, history bool) error {
+	var media, download interface{}
+	if m.Media != nil {
+		b, err := json.Marshal(m.Media)
+		if err != nil {
+			return err
+		}
+		media = string(b)
+	}
+	if m.Download != nil {
+		b, err := json.Marshal(m.Download)
+		if err != nil {
+			return err
+		}
+		download = string(b)
+	}
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now()
+	}
+
+	err := postgres.DB.QueryRow(
+		`INSERT INTO messages (instance_id, message_id, chat_jid, sender_jid, push_name, direction, type, body,
+			transcription, media, media_download, status, timestamp, raw_sender_jid)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (instance_id, chat_jid, message_id) DO NOTHING
+		RETURNING id`,
+		m.InstanceID, m.MessageID, m.ChatJID, m.SenderJID, m.PushName, m.Direction, m.Type, m.Body,
+		m.Transcription, media, download, m.Status, m.Timestamp.UTC(), m.RawSenderJID,
+	).Scan(&m.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Mensagem repetida: o chat já foi atualizado
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao salvar mensagem: %w", err)
+	}
+
+	// Em conversas privadas o push name de quem escreveu nomeia o chat
+	name := ""
+	if m.Direction == Inbound && !strings.HasSuffix(m.ChatJID, "@g.us") {
+		name = m.PushName
+	}
+	if history {
+		return touchChat(m, name, false, false)
+	}
+	return touchChat(m, name, m.Direction == Inbound, m.Direction == Outbound)
+}
+
+// SetTranscription grava a transcrição de um áudio já salvo
+func SetTranscription(instanceID, chatJID, messageID, text string) error {
+	_, err := postgres.DB.Exec(
+		`UPDATE messages SET transcription = $1, updated_at = NOW()
+		WHERE instance_id = $2 AND chat_jid = $3 AND message_id = $4`,
+		text, instanceID, chatJID, messageID,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao salvar transcrição: %w", err)
+	}
+	return nil
+}
+
+// Edit troca o texto de uma mensagem editada pelo remetente. A busca passa a
+// encontrar o novo texto, já que o índice é calculado sobre body. Mensagens
+// apagadas não voltam a ter texto.
+func Edit(instanceID, chatJID, messageID, body string, at time.Time) error {
+	var msgType string
+	err := postgres.DB.QueryRow(
+		`UPDATE messages SET body = $1, edited_at = $2, updated_at = NOW()
+		WHERE instance_id = $3 AND chat_jid = $4 AND message_id = $5 AND revoked_at IS NULL
+		RETURNING type`,
+		body, at.UTC(), instanceID, chatJID, messageID,
+	).Scan(&msgType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao editar mensagem: %w", err)
+	}
+	return touchPreview(instanceID, chatJID, messageID, preview(&Message{Type: msgType, Body: body}))
+}
+
+// Revoke marca uma mensagem como apagada para todos e descarta o texto e a
+// transcrição guardados, que deixam de aparecer no histórico e na busca
+func Revoke(instanceID, chatJID, messageID string, at time.Time) error {
+	res, err := postgres.DB.Exec(
+		`UPDATE messages SET body = '', transcription = '', revoked_at = $1, updated_at = NOW()
+		WHERE instance_id = $2 AND chat_jid = $3 AND message_id = $4 AND revoked_at IS NULL`,
+		at.UTC(), instanceID, chatJID, messageID,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao apagar mensagem: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	return touchPreview(instanceID, chatJID, messageID, TypeLabel("revoke"))
+}
+
+// UpdateStatus avança o status das mensagens enviadas no chat com os ids
+// informados e retorna os ids que mudaram. Status menores ou iguais ao atual
+// são ignorados.
+func UpdateStatus(instanceID, chatJID string, messageIDs []string, status string, at time.Time) ([]string, error) {
+	return updateStatus(postgres.DB, instanceID, chatJID, messageIDs, status, at)
+}
+
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func updateStatus(q querier, instanceID, chatJID string, messageIDs []string, status string, at time.Time) ([]string, error) {
+	rank, ok := statusRank[status]
+	if !ok {
+		return nil, fmt.Errorf("status inválido: %s", status)
+	}
+	rows, err := q.Query(
+		`UPDATE messages SET status = $1, status_at = $2, updated_at = NOW()
+		WHERE instance_id = $3 AND chat_jid = $4 AND message_id = ANY($5) AND direction = $6
+			AND (CASE status WHEN 'server_ack' THEN 1 WHEN 'delivered' THEN 2 WHEN 'read' THEN 3
+				WHEN 'played' THEN 4 ELSE 0 END) < $7
+		RETURNING message_id`,
+		status, at.UTC(), instanceID, chatJID, pq.Array(messageIDs), Outbound, rank,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao atualizar status: %w", err)
+	}
+	defer rows.Close()
+	changed := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("erro ao atualizar status: %w", err)
+		}
+		changed = append(changed, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao atualizar status: %w", err)
+	}
+	return changed, nil
+}
+
+// Tempo que um recibo de mensagem ainda não gravada fica guardado. Recibos de
+// mensagens que nunca entram no histórico (enviadas pelo celular sem
+// emit_from_me) são descartados depois disso.
+const pendingReceiptTTL = time.Hour
+
+// Receipt é um recibo aplicado a uma mensagem enviada
+type Receipt struct {
+	MessageID   string
+	Status      string
+	At          time.Time
+	Participant string
+}
+
+// SavePendingReceipts guarda recibos de mensagens enviadas que ainda não
+// estavam no histórico quando chegaram (a fila grava a mensagem logo depois
+// do envio). ApplyPendingReceipts os aplica quando a mensagem existir.
+func SavePendingReceipts(instanceID, chatJID string, messageIDs []string, status string, at time.Time, participant string) error {
+	_, err := postgres.DB.Exec(
+		`INSERT INTO pending_receipts (instance_id, chat_jid, message_id, status, status_at, participant)
+		SELECT $1, $2, id, $4, $5, $6 FROM unnest($3::text[]) AS id
+		ON CONFLICT (instance_id, chat_jid, message_id, status, participant) DO NOTHING`,
+		instanceID, chatJID, pq.Array(messageIDs), status, at.UTC(), participant,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao guardar recibo: %w", err)
+	}
+	_, err = postgres.DB.Exec(`DELETE FROM pending_receipts WHERE received_at < $1`, time.Now().Add(-pendingReceiptTTL))
+	if err != nil {
+		return fmt.Errorf("erro ao descartar recibos antigos: %w", err)
+	}
+	return nil
+}
+
+// ApplyPendingReceipts aplica os recibos guardados das mensagens já gravadas
+// e retorna os que mudaram o status. Recibos de mensagens ainda ausentes
+// continuam guardados: tanto quem grava a mensagem quanto quem guarda o
+// recibo chama esta função, e a última delas encontra os dois.
+func ApplyPendingReceipts(instanceID, chatJID string, messageIDs []string) ([]Receipt, error) {
+	tx, err := postgres.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao aplicar recibos: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`DELETE FROM pending_receipts p USING messages m
+		WHERE p.instance_id = $1 AND p.chat_jid = $2 AND p.message_id = ANY($3)
+			AND m.instance_id = p.instance_id AND m.chat_jid = p.chat_jid AND m.message_id = p.message_id
+		RETURNING p.message_id, p.status, p.status_at, p.participant`,
+		instanceID, chatJID, pq.Array(messageIDs),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao aplicar recibos: %w", err)
+	}
+	var pending []Receipt
+	for rows.Next() {
+		var r Receipt
+		if err := rows.Scan(&r.MessageID, &r.Status, &r.At, &r.Participant); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("erro ao aplicar recibos: %w", err)
+		}
+		pending = append(pending, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao aplicar recibos: %w", err)
+	}
+
+	// Do status mais baixo ao mais alto, como chegariam em ordem
+	sort.SliceStable(pending, func(i, j int) bool {
+		return statusRank[pending[i].Status] < statusRank[pending[j].Status]
+	})
+	applied := []Receipt{}
+	for _, r := range pending {
+		changed, err := updateStatus(tx, instanceID, chatJID, []string{r.MessageID}, r.Status, r.At)
+		if err != nil {
+			return nil, err
+		}
+		if len(changed) > 0 {
+			applied = append(applied, r)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("erro ao aplicar recibos: %w", err)
+	}
+	return applied, nil
+}
+
+// FindInbound retorna as mensagens recebidas no chat com os ids informados
+func FindInbound(instanceID, chatJID string, messageIDs []string) ([]Message, error) {
+	rows, err := postgres.DB.Query(
+		`SELECT `+messageColumns+` FROM messages
+		WHERE instance_id = $1 AND chat_jid = $2 AND message_id = ANY($3) AND direction = $4
+		ORDER BY timestamp`,
+		instanceID, chatJID, pq.Array(messageIDs), Inbound,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar mensagens: %w", err)
+	}
+	return scanMessages(rows)
+}
+
+// UnreadUpTo retorna as mensagens recebidas ainda não lidas do chat até a
+// mensagem informada (inclusive), que pode ser recebida ou enviada
+func UnreadUpTo(instanceID, chatJID, messageID string) ([]Message, error) {
+	var until time.Time
+	err := postgres.DB.QueryRow(
+		`SELECT timestamp FROM messages WHERE instance_id = $1 AND chat_jid = $2 AND message_id = $3`,
+		instanceID, chatJID, messageID,
+	).Scan(&until)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar mensagem: %w", err)
+	}
+
+	rows, err := postgres.DB.Query(
+		`SELECT `+messageColumns+` FROM messages
+		WHERE instance_id = $1 AND chat_jid = $2 AND direction = $3 AND status = $4 AND timestamp <= $5
+		ORDER BY timestamp`,
+		instanceID, chatJID, Inbound, StatusReceived, until,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar mensagens: %w", err)
+	}
+	return scanMessages(rows)
+}
+
+// MarkRead marca como lidas as mensagens recebidas do chat e desconta do
+// contador de não lidas as que ainda não tinham sido lidas
+func MarkRead(instanceID, chatJID string, messageIDs []string, at time.Time) error {
+	res, err := postgres.DB.Exec(
+		`UPDATE messages SET status = $1, status_at = $2, updated_at = NOW()
+		WHERE instance_id = $3 AND chat_jid = $4 AND message_id = ANY($5) AND direction = $6 AND status = $7`,
+		StatusRead, at.UTC(), instanceID, chatJID, pq.Array(messageIDs), Inbound, StatusReceived,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao marcar mensagens como lidas: %w", err)
+	}
+	read, _ := res.RowsAffected()
+	if read == 0 {
+		return nil
+	}
+	_, err = postgres.DB.Exec(
+		`UPDATE chats SET unread_count = GREATEST(unread_count - $1, 0), updated_at = NOW()
+		WHERE instance_id = $2 AND chat_jid = $3`,
+		read, instanceID, chatJID,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao atualizar chat: %w", err)
+	}
+	return nil
+}
+
+// SetMedia grava os metadados da mídia de uma mensagem já salva
+func SetMedia(instanceID, chatJID, messageID string, media *Media) error {
+	b, err := json.Marshal(media)
+	if err != nil {
+		return err
+	}
+	_, err = postgres.DB.Exec(
+		`UPDATE messages SET media = $1, updated_at = NOW()
+		WHERE instance_id = $2 AND chat_jid = $3 AND message_id = $4`,
+		string(b), instanceID, chatJID, messageID,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao salvar mídia: %w", err)
+	}
+	return nil
+}
+
+// FindMedia retorna a mensagem e os dados para baixar sua mídia. Sem chatJID
+// vale a mensagem mais recente com o id (ids se repetem só entre chats).
+func FindMedia(instanceID, chatJID, messageID string) (*Message, *MediaDownload, error) {
+	var download []byte
+	row := postgres.DB.QueryRow(
+		`SELECT `+messageColumns+`, media_download FROM messages
+		WHERE instance_id = $1 AND message_id = $2 AND ($3 = '' OR chat_jid = $3)
+		ORDER BY timestamp DESC LIMIT 1`,
+		instanceID, messageID, chatJID,
+	)
+	m, err := scanMessage(extraScanner{row, []interface{}{&download}})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("erro ao buscar mensagem: %w", err)
+	}
+	if len(download) == 0 || m.Media == nil {
+		return m, nil, ErrNoMedia
+	}
+	var d MediaDownload
+	if err := json.Unmarshal(download, &d); err != nil {
+		return nil, nil, fmt.Errorf("erro ao ler dados da mídia: %w", err)
+	}
+	return m, &d, nil
+}
+
+// List retorna as mensagens do chat da mais recente para a mais antiga e o
+// cursor da próxima página (vazio na última)
+func List(instanceID, chatJID string, f Filter) ([]Message, string, error) {
+	conds := []string{"instance_id = $1", "chat_jid = $2"}
+	args := []interface{}{instanceID, chatJID}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, strings.ReplaceAll(cond, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if f.Cursor != "" {
+		ts, id, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		args = append(args, ts, id)
+		conds = append(conds, fmt.Sprintf("(timestamp, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+	if !f.Since.IsZero() {
+		add("timestamp >= ?", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		add("timestamp <= ?", f.Until.UTC())
+	}
+	args = append(args, f.Limit+1)
+
+	rows, err := postgres.DB.Query(
+		`SELECT `+messageColumns+` FROM messages
+		WHERE `+strings.Join(conds, " AND ")+`
+		ORDER BY timestamp DESC, id DESC LIMIT $`+strconv.Itoa(len(args)),
+		args...,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("erro ao listar mensagens: %w", err)
+	}
+	defer rows.Close()
+
+	list := []Message{}
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, "", fmt.Errorf("erro ao listar mensagens: %w", err)
+		}
+		list = append(list, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("erro ao listar mensagens: %w", err)
+	}
+
+	// A linha a mais indica que existe outra página
+	next := ""
+	if len(list) > f.Limit {
+		list = list[:f.Limit]
+		last := list[len(list)-1]
+		next = encodeCursor(last.Timestamp, last.ID)
+	}
+	return list, next, nil
+}
+
+// O cursor é opaco para o cliente: timestamp e id da última mensagem da página
+func encodeCursor(ts time.Time, id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", ts.UnixMicro(), id)))
+}
+
+func decodeCursor(cursor string) (time.Time, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	micros, err1 := strconv.ParseInt(parts[0], 10, 64)
+	id, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	return time.UnixMicro(micros).UTC(), id, nil
+}
+
+// SearchFilter restringe a busca textual nas mensagens da instância
+type SearchFilter struct {
+	Query   string
+	ChatJID string
+	Sender  string
+	Type    string
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+	Offset  int
+}
+
+// SearchResult é uma mensagem encontrada pela busca, com a relevância
+type SearchResult struct {
+	Message
+	Rank float64 `json:"rank"`
+}
+
+// Search procura no texto, nas legendas e nas transcrições usando a busca
+// textual do PostgreSQL (sintaxe de websearch: "frase exata", -excluir, or).
+// O resultado vem do mais relevante para o menos relevante.
+func Search(instanceID string, f SearchFilter) ([]SearchResult, error) {
+	vector := postgres.SearchVector()
+	query := fmt.Sprintf("websearch_to_tsquery('%s', $2)", postgres.SearchLanguage())
+
+	conds := []string{"instance_id = $1", vector + " @@ " + query}
+	args := []interface{}{instanceID, f.Query}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, strings.ReplaceAll(cond, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if f.ChatJID != "" {
+		add("chat_jid = ?", f.ChatJID)
+	}
+	if f.Sender != "" {
+		add("sender_jid = ?", f.Sender)
+	}
+	if f.Type != "" {
+		add("type = ?", f.Type)
+	}
+	if !f.Since.IsZero() {
+		add("timestamp >= ?", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		add("timestamp <= ?", f.Until.UTC())
+	}
+	args = append(args, f.Limit, f.Offset)
+
+	rows, err := postgres.DB.Query(
+		`SELECT `+messageColumns+`, ts_rank(`+vector+`, `+query+`) AS rank FROM messages
+		WHERE `+strings.Join(conds, " AND ")+`
+		ORDER BY rank DESC, timestamp DESC, id DESC
+		LIMIT $`+strconv.Itoa(len(args)-1)+` OFFSET $`+strconv.Itoa(len(args)),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar mensagens: %w", err)
+	}
+	defer rows.Close()
+
+	list := []SearchResult{}
+	for rows.Next() {
+		var rank float64
+		m, err := scanMessage(extraScanner{rows, []interface{}{&rank}})
+		if err != nil {
+			return nil, fmt.Errorf("erro ao buscar mensagens: %w", err)
+		}
+		list = append(list, SearchResult{Message: *m, Rank: rank})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao buscar mensagens: %w", err)
+	}
+	return list, nil
+}
+
+// extraScanner lê colunas extras depois das colunas da mensagem
+type extraScanner struct {
+	row   rowScanner
+	extra []interface{}
+}
+
+func (s extraScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
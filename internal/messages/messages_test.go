@@ -0,0 +1,64 @@
+package messages
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		ts time.Time
+		id int64
+	}{
+		{time.Date(2026, 2, 19, 13, 0, 0, 0, time.UTC), 42},
+		{time.Date(2026, 2, 19, 13, 0, 0, 123456000, time.UTC), 1},
+		{time.Date(1999, 12, 31, 23, 59, 59, 0, time.UTC), 9223372036854775807},
+		{time.Unix(0, 0).UTC(), 0},
+		// Horários fora de UTC voltam em UTC, no mesmo instante
+		{time.Date(2026, 2, 19, 10, 0, 0, 0, time.FixedZone("BRT", -3*3600)), 7},
+	}
+	for _, tt := range tests {
+		cursor := encodeCursor(tt.ts, tt.id)
+		ts, id, err := decodeCursor(cursor)
+		if err != nil {
+			t.Fatalf("decodeCursor(%q): %v", cursor, err)
+		}
+		if !ts.Equal(tt.ts) || ts.Location() != time.UTC || id != tt.id {
+			t.Errorf("cursor %q = (%s, %d), want (%s, %d)", cursor, ts, id, tt.ts.UTC(), tt.id)
+		}
+	}
+}
+
+func TestEncodeCursor(t *testing.T) {
+	// Mesmo cursor do exemplo no README
+	got := encodeCursor(time.Date(2026, 2, 19, 13, 0, 0, 0, time.UTC), 42)
+	if want := "MTc3MTUwNjAwMDAwMDAwMDo0Mg"; got != want {
+		t.Errorf("encodeCursor = %q, want %q", got, want)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	enc := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"vazio", ""},
+		{"base64 inválido", "não é base64!"},
+		{"base64 com padding", base64.URLEncoding.EncodeToString([]byte("1771506000000000:42"))},
+		{"sem separador", enc("1771506000000000")},
+		{"horário não numérico", enc("ontem:42")},
+		{"id não numérico", enc("1771506000000000:abc")},
+		{"id vazio", enc("1771506000000000:")},
+		{"separador extra", enc("1771506000000000:42:1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeCursor(tt.cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeCursor(%q) err = %v, want ErrInvalidCursor", tt.cursor, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"wapi/config"
+	"wapi/internal/service"
+	"wapi/store/postgres"
+
+	"github.com/google/uuid"
+)
+
+// Estados de um job de envio
+const (
+	StatusQueued      = "queued"
+	StatusProcessing  = "processing"
+	StatusDownloading = "downloading"
+	StatusConverting  = "converting"
+	StatusUploading   = "uploading"
+	StatusSent        = "sent"
+	StatusFailed      = "failed"
+)
+
+// Tipos de job
+const (
+	KindText  = "text"
+	KindMedia = "media"
+)
+
+var ErrJobNotFound = errors.New("job não encontrado")
+
+type Job struct {
+	ID         string    `json:"id"`
+	InstanceID string    `json:"instance_id"`
+	Recipient  string    `json:"recipient"`
+	Kind       string    `json:"kind"`
+	Message    string    `json:"message,omitempty"`
+	MediaURL   string    `json:"media_url,omitempty"`
+	MediaData  []byte    `json:"-"`
+	Mimetype   string    `json:"mimetype,omitempty"`
+	Filename   string    `json:"filename,omitempty"`
+	Caption    string    `json:"caption,omitempty"`
+	MediaType  string    `json:"media_type,omitempty"`
+	Status     string    `json:"status"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	// Preenchido quando o job é enviado
+	Result *service.SendResult `json:"result,omitempty"`
+}
+
+// MediaInput descreve a mídia a ser enfileirada: dados em memória (base64) ou URL
+type MediaInput struct {
+	Data      []byte
+	URL       string
+	Mimetype  string
+	Filename  string
+	Caption   string
+	MediaType string
+}
+
+const jobColumns = `id, instance_id, recipient, kind, message, media_url, media_data, mimetype, filename,
+	caption, media_type, status, attempts, last_error, created_at, updated_at, message_id, server_timestamp,
+	recipient_jid`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row rowScanner) (*Job, error) {
+	var j Job
+	var messageID, recipientJID string
+	var serverTimestamp sql.NullTime
+	err := row.Scan(&j.ID, &j.InstanceID, &j.Recipient, &j.Kind, &j.Message, &j.MediaURL, &j.MediaData,
+		&j.Mimetype, &j.Filename, &j.Caption, &j.MediaType, &j.Status, &j.Attempts, &j.LastError,
+		&j.CreatedAt, &j.UpdatedAt, &messageID, &serverTimestamp, &recipientJID)
+	if err != nil {
+		return nil, err
+	}
+	if messageID != "" {
+		j.Result = &service.SendResult{
+			MessageID:    messageID,
+			Timestamp:    serverTimestamp.Time,
+			RecipientJID: recipientJID,
+		}
+	}
+	return &j, nil
+}
+
+func insertJob(j *Job) error {
+	err := postgres.DB.QueryRow(
+		`INSERT INTO outbound_jobs (instance_id, recipient, kind, message, media_url, media_data, mimetype,
+			filename, caption, media_type, max_attempts)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, status, created_at, updated_at`,
+		j.InstanceID, j.Recipient, j.Kind, j.Message, j.MediaURL, j.MediaData, j.Mimetype,
+		j.Filename, j.Caption, j.MediaType, config.App.QueueMaxAttempts,
+	).Scan(&j.ID, &j.Status, &j.CreatedAt, &j.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("erro ao enfileirar mensagem: %w", err)
+	}
+	return nil
+}
+
+// Get busca um job de envio da instância. Um id que não é UUID não existe.
+func Get(instanceID, id string) (*Job, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrJobNotFound
+	}
+	j, err := scanJob(postgres.DB.QueryRow(
+		`SELECT `+jobColumns+` FROM outbound_jobs WHERE id = $1 AND instance_id = $2`, id, instanceID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar job: %w", err)
+	}
+	return j, nil
+}
+
+// claimNext reserva o próximo job pronto da instância. Um job só é elegível se
+// não houver job anterior pendente para o mesmo destinatário, garantindo a
+// ordem de entrega por conversa mesmo durante o backoff.
+func claimNext(instanceID string) (*Job, error) {
+	j, err := scanJob(postgres.DB.QueryRow(
+		`UPDATE outbound_jobs SET status = 'processing', attempts = attempts + 1, updated_at = NOW()
+		WHERE id = (
+			SELECT j.id FROM outbound_jobs j
+			WHERE j.instance_id = $1 AND j.status = 'queued' AND j.next_attempt_at <= NOW()
+			AND NOT EXISTS (
+				SELECT 1 FROM outbound_jobs p
+				WHERE p.instance_id = j.instance_id AND p.recipient = j.recipient
+				AND p.status NOT IN ('sent', 'failed') AND p.seq < j.seq
+			)
+			ORDER BY j.seq
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns, instanceID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return j, err
+}
+
+// setStatus atualiza a etapa atual do job em processamento
+func setStatus(j *Job, status string) error {
+	j.Status = status
+	_, err := postgres.DB.Exec(`UPDATE outbound_jobs SET status = $1, updated_at = NOW() WHERE id = $2`, status, j.ID)
+	if err != nil {
+		return fmt.Errorf("erro ao atualizar job %s: %w", j.ID, err)
+	}
+	return nil
+}
+
+func markSent(j *Job, result *service.SendResult) error {
+	j.Status = StatusSent
+	j.LastError = ""
+	j.Result = result
+	_, err := postgres.DB.Exec(
+		`UPDATE outbound_jobs SET status = 'sent', media_data = NULL, last_error = '', message_id = $1,
+			server_timestamp = $2, recipient_jid = $3, updated_at = NOW()
+		WHERE id = $4`,
+		result.MessageID, result.Timestamp, result.RecipientJID, j.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao marcar job %s como enviado: %w", j.ID, err)
+	}
+	return nil
+}
+
+// markFailed reagenda o job com backoff ou o marca como falho ao esgotar as tentativas
+func markFailed(j *Job, sendErr error) (final bool, err error) {
+	var maxAttempts int
+	err = postgres.DB.QueryRow(`SELECT max_attempts FROM outbound_jobs WHERE id = $1`, j.ID).Scan(&maxAttempts)
+	if err != nil {
+		return false, fmt.Errorf("erro ao buscar job %s: %w", j.ID, err)
+	}
+
+	j.LastError = sendErr.Error()
+	if j.Attempts >= maxAttempts {
+		j.Status = StatusFailed
+		_, err = postgres.DB.Exec(
+			`UPDATE outbound_jobs SET status = 'failed', media_data = NULL, last_error = $1, updated_at = NOW() WHERE id = $2`,
+			sendErr.Error(), j.ID,
+		)
+		if err != nil {
+			return true, fmt.Errorf("erro ao marcar job %s como falho: %w", j.ID, err)
+		}
+		return true, nil
+	}
+
+	j.Status = StatusQueued
+	_, err = postgres.DB.Exec(
+		`UPDATE outbound_jobs SET status = 'queued', last_error = $1, next_attempt_at = $2, updated_at = NOW() WHERE id = $3`,
+		sendErr.Error(), time.Now().Add(backoff(j.Attempts)), j.ID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("erro ao reagendar job %s: %w", j.ID, err)
+	}
+	return false, nil
+}
+
+// Recover devolve à fila os jobs que estavam em processamento quando o servidor parou
+func Recover() error {
+	res, err := postgres.DB.Exec(
+		`UPDATE outbound_jobs SET status = 'queued', updated_at = NOW() WHERE status NOT IN ('queued', 'sent', 'failed')`,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao recuperar fila de envio: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[QUEUE] %d job(s) em processamento devolvido(s) à fila", n)
+	}
+	return nil
+}
+
+func backoff(attempt int) time.Duration {
+	d := 5 * time.Second << (attempt - 1)
+	if d > 5*time.Minute || d <= 0 {
+		d = 5 * time.Minute
+	}
+	return d
+}
@@ -0,0 +1,308 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"wapi/internal/instance"
+	"wapi/internal/messages"
+	"wapi/internal/service"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+const pollInterval = 2 * time.Second
+
+type worker struct {
+	inst   *instance.Instance
+	wake   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Manager mantém um worker de envio por instância
+type Manager struct {
+	workers map[string]*worker
+	mu      sync.Mutex
+
+	// Canais de quem aguarda um job (Wait), fechados quando ele termina
+	waiters   map[string][]chan struct{}
+	waitersMu sync.Mutex
+}
+
+var Global = &Manager{
+	workers: make(map[string]*worker),
+	waiters: make(map[string][]chan struct{}),
+}
+
+// Start inicia o worker de envio da instância (idempotente)
+func (m *Manager) Start(inst *instance.Instance) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.workers[inst.ID]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &worker{
+		inst:   inst,
+		wake:   make(chan struct{}, 1),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	m.workers[inst.ID] = w
+	go w.run(ctx)
+}
+
+// Stop encerra o worker da instância. Os jobs pendentes continuam no banco.
+func (m *Manager) Stop(instanceID string) {
+	m.mu.Lock()
+	w, ok := m.workers[instanceID]
+	delete(m.workers, instanceID)
+	m.mu.Unlock()
+	if ok {
+		w.cancel()
+	}
+}
+
+// StopAll encerra todos os workers aguardando o job em andamento terminar.
+// O que não terminar dentro do timeout volta para a fila no próximo start (Recover).
+func (m *Manager) StopAll(timeout time.Duration) {
+	m.mu.Lock()
+	workers := make([]*worker, 0, len(m.workers))
+	for id, w := range m.workers {
+		w.cancel()
+		workers = append(workers, w)
+		delete(m.workers, id)
+	}
+	m.mu.Unlock()
+
+	deadline := time.After(timeout)
+	for _, w := range workers {
+		select {
+		case <-w.done:
+		case <-deadline:
+			log.Printf("[QUEUE] Timeout aguardando workers, jobs em andamento serão retomados no próximo start")
+			return
+		}
+	}
+}
+
+func (m *Manager) notify(instanceID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if w, ok := m.workers[instanceID]; ok {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// subscribe registra um canal fechado quando o job terminar
+func (m *Manager) subscribe(id string) chan struct{} {
+	ch := make(chan struct{})
+	m.waitersMu.Lock()
+	m.waiters[id] = append(m.waiters[id], ch)
+	m.waitersMu.Unlock()
+	return ch
+}
+
+func (m *Manager) unsubscribe(id string, ch chan struct{}) {
+	m.waitersMu.Lock()
+	defer m.waitersMu.Unlock()
+	list := m.waiters[id]
+	for i, c := range list {
+		if c == ch {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(m.waiters, id)
+	} else {
+		m.waiters[id] = list
+	}
+}
+
+// finished acorda quem aguarda o job
+func (m *Manager) finished(id string) {
+	m.waitersMu.Lock()
+	list := m.waiters[id]
+	delete(m.waiters, id)
+	m.waitersMu.Unlock()
+	for _, ch := range list {
+		close(ch)
+	}
+}
+
+// Wait aguarda o job terminar (sent ou failed) e retorna seu estado final.
+// O worker avisa ao terminar; se o timeout expirar, retorna o estado atual do job.
+func Wait(ctx context.Context, instanceID, id string, timeout time.Duration) (*Job, error) {
+	// Registra antes de consultar para não perder um término entre os dois
+	ch := Global.subscribe(id)
+	defer Global.unsubscribe(id, ch)
+
+	j, err := Get(instanceID, id)
+	if err != nil {
+		return nil, err
+	}
+	if j.Status == StatusSent || j.Status == StatusFailed {
+		return j, nil
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return j, nil
+	case <-timer.C:
+		return j, nil
+	case <-ch:
+	}
+	return Get(instanceID, id)
+}
+
+// EnqueueText enfileira uma mensagem de texto
+func EnqueueText(inst *instance.Instance, to, message string) (*Job, error) {
+	j := &Job{
+		InstanceID: inst.ID,
+		Recipient:  to,
+		Kind:       KindText,
+		Message:    message,
+	}
+	if err := insertJob(j); err != nil {
+		return nil, err
+	}
+	Global.notify(inst.ID)
+	return j, nil
+}
+
+// EnqueueMedia enfileira uma mídia (base64 já decodificado ou URL a ser baixada pelo worker)
+func EnqueueMedia(inst *instance.Instance, to string, media MediaInput) (*Job, error) {
+	j := &Job{
+		InstanceID: inst.ID,
+		Recipient:  to,
+		Kind:       KindMedia,
+		MediaURL:   media.URL,
+		MediaData:  media.Data,
+		Mimetype:   media.Mimetype,
+		Filename:   media.Filename,
+		Caption:    media.Caption,
+		MediaType:  media.MediaType,
+	}
+	if err := insertJob(j); err != nil {
+		return nil, err
+	}
+	Global.notify(inst.ID)
+	return j, nil
+}
+
+func (w *worker) run(ctx context.Context) {
+	defer close(w.done)
+	for {
+		if w.inst.WAClient != nil && w.inst.WAClient.IsConnected() {
+			j, err := claimNext(w.inst.ID)
+			if err != nil {
+				log.Printf("[QUEUE] Erro ao buscar job da instância %s: %v", w.inst.Name, err)
+			} else if j != nil {
+				w.process(j)
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (w *worker) process(j *Job) {
+	result, err := w.send(j)
+	if err != nil {
+		final, dbErr := markFailed(j, err)
+		if dbErr != nil {
+			// O job fica em processamento e volta para a fila no próximo start (Recover)
+			log.Printf("[QUEUE] Job %s falhou: %v (%v)", j.ID, err, dbErr)
+			if !final {
+				return
+			}
+		}
+		if final {
+			log.Printf("[QUEUE] Job %s falhou definitivamente após %d tentativa(s): %v", j.ID, j.Attempts, err)
+			Global.finished(j.ID)
+			w.publishStatus(j)
+		} else {
+			log.Printf("[QUEUE] Job %s falhou (tentativa %d), reagendado: %v", j.ID, j.Attempts, err)
+		}
+		return
+	}
+	if err := markSent(j, result); err != nil {
+		log.Printf("[QUEUE] %v", err)
+	}
+	Global.finished(j.ID)
+	log.Printf("[QUEUE] Job %s enviado para %s (mensagem %s)", j.ID, j.Recipient, result.MessageID)
+	if result.Message != nil {
+		if err := messages.Save(result.Message); err != nil {
+			log.Printf("[QUEUE] Job %s: %v", j.ID, err)
+		}
+	}
+	w.publishStatus(j)
+	if jid, err := types.ParseJID(result.RecipientJID); err == nil {
+		w.inst.PublishMessageStatus(jid.ToNonAD(), []string{result.MessageID}, messages.StatusServerAck, result.Timestamp, "")
+	}
+	// Recibos que chegaram antes de a mensagem ser gravada
+	if result.Message != nil {
+		w.inst.ApplyPendingReceipts(result.Message.ChatJID, []string{result.MessageID})
+	}
+}
+
+// publishStatus notifica o resultado final do job via SSE e webhook (send.status)
+func (w *worker) publishStatus(j *Job) {
+	data := map[string]interface{}{
+		"job_id":    j.ID,
+		"status":    j.Status,
+		"recipient": j.Recipient,
+		"kind":      j.Kind,
+		"attempts":  j.Attempts,
+	}
+	if j.LastError != "" {
+		data["error"] = j.LastError
+	}
+	if j.Result != nil {
+		data["message_id"] = j.Result.MessageID
+		data["timestamp"] = j.Result.Timestamp
+		data["recipient_jid"] = j.Result.RecipientJID
+	}
+	w.inst.PublishEvent("send.status", data)
+}
+
+func (w *worker) send(j *Job) (*service.SendResult, error) {
+	switch j.Kind {
+	case KindText:
+		return service.SendText(w.inst, j.Recipient, j.Message)
+	case KindMedia:
+		data, mimetype, filename := j.MediaData, j.Mimetype, j.Filename
+		if j.MediaURL != "" {
+			if err := setStatus(j, StatusDownloading); err != nil {
+				log.Printf("[QUEUE] %v", err)
+			}
+			var err error
+			data, mimetype, filename, err = service.DownloadFromURL(j.MediaURL)
+			if err != nil {
+				return nil, err
+			}
+		}
+		isAudio := service.IsAudio(j.MediaType, mimetype, filename)
+		return service.SendMedia(w.inst, j.Recipient, data, mimetype, filename, j.Caption, isAudio, func(stage string) {
+			if err := setStatus(j, stage); err != nil {
+				log.Printf("[QUEUE] %v", err)
+			}
+		})
+	}
+	return nil, fmt.Errorf("tipo de job desconhecido: %s", j.Kind)
+}
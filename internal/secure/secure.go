@@ -0,0 +1,149 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Formato dos dados cifrados: magic | nonce (12) | AES-256-GCM(dados).
+// A chave é derivada da MASTER_KEY com HMAC-SHA256.
+var magic = []byte("WAPIENC1")
+
+const (
+	minKeyLen  = 16
+	hashPrefix = "sha256:"
+)
+
+var (
+	ErrNoMasterKey   = errors.New("MASTER_KEY não configurada")
+	ErrWeakMasterKey = fmt.Errorf("MASTER_KEY deve ter ao menos %d caracteres", minKeyLen)
+	ErrNotEncrypted  = errors.New("dados não estão cifrados")
+	ErrWrongKey      = errors.New("chave incorreta ou dados corrompidos")
+)
+
+// Cipher cifra e decifra dados com uma chave mestra
+type Cipher struct {
+	gcm      cipher.AEAD
+	nonceKey []byte
+}
+
+// Global é o cipher da MASTER_KEY configurada. Fica nil quando a criptografia
+// em repouso está desligada.
+var Global *Cipher
+
+// Init configura o cipher global. Uma chave vazia desliga a criptografia.
+func Init(masterKey string) error {
+	if masterKey == "" {
+		Global = nil
+		return nil
+	}
+	c, err := NewCipher(masterKey)
+	if err != nil {
+		return err
+	}
+	Global = c
+	return nil
+}
+
+// Enabled indica se a criptografia em repouso está ligada
+func Enabled() bool {
+	return Global != nil
+}
+
+func NewCipher(masterKey string) (*Cipher, error) {
+	if masterKey == "" {
+		return nil, ErrNoMasterKey
+	}
+	if len(masterKey) < minKeyLen {
+		return nil, ErrWeakMasterKey
+	}
+	block, err := aes.NewCipher(deriveKey(masterKey, "wapi-at-rest"))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{gcm: gcm, nonceKey: deriveKey(masterKey, "wapi-at-rest-nonce")}, nil
+}
+
+func deriveKey(masterKey, label string) []byte {
+	mac := hmac.New(sha256.New, []byte(masterKey))
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+func (c *Cipher) Encrypt(plain []byte) ([]byte, error) {
+	nonce := make([]byte, c.gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(magic)+len(nonce)+len(plain)+c.gcm.Overhead())
+	out = append(out, magic...)
+	out = append(out, nonce...)
+	return c.gcm.Seal(out, nonce, plain, magic), nil
+}
+
+// EncryptDeterministic cifra com o nonce derivado do próprio conteúdo: o mesmo
+// valor gera sempre o mesmo resultado, o que permite buscas por igualdade no
+// banco. Revela apenas quais valores são iguais. Decrypt abre os dois formatos.
+func (c *Cipher) EncryptDeterministic(plain []byte) []byte {
+	mac := hmac.New(sha256.New, c.nonceKey)
+	mac.Write(plain)
+	nonce := mac.Sum(nil)[:c.gcm.NonceSize()]
+	out := make([]byte, 0, len(magic)+len(nonce)+len(plain)+c.gcm.Overhead())
+	out = append(out, magic...)
+	out = append(out, nonce...)
+	return c.gcm.Seal(out, nonce, plain, magic)
+}
+
+func (c *Cipher) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, ErrNotEncrypted
+	}
+	nonceSize := c.gcm.NonceSize()
+	if len(data) < len(magic)+nonceSize {
+		return nil, ErrWrongKey
+	}
+	nonce := data[len(magic) : len(magic)+nonceSize]
+	plain, err := c.gcm.Open(nil, nonce, data[len(magic)+nonceSize:], magic)
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return plain, nil
+}
+
+// IsEncrypted indica se os dados foram gerados por Encrypt
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// HashSecret gera o hash guardado no lugar de API keys e tokens. Os segredos
+// são aleatórios, então SHA-256 sem salt basta e permite a busca pelo hash.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// IsHashed indica se o valor guardado já é um hash de HashSecret
+func IsHashed(value string) bool {
+	return strings.HasPrefix(value, hashPrefix)
+}
+
+// CompareSecret compara o segredo recebido com o hash guardado em tempo constante
+func CompareSecret(hash, secret string) bool {
+	if hash == "" || secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(HashSecret(secret))) == 1
+}
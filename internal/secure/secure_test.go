@@ -0,0 +1,171 @@
+package secure
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+const testKey = "chave-mestra-de-teste"
+
+func newTestCipher(t *testing.T, key string) *Cipher {
+	t.Helper()
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestNewCipherKey(t *testing.T) {
+	tests := []struct {
+		key string
+		err error
+	}{
+		{"", ErrNoMasterKey},
+		{"curta", ErrWeakMasterKey},
+		{"123456789012345", ErrWeakMasterKey},
+		{"1234567890123456", nil},
+		{testKey, nil},
+	}
+	for _, tt := range tests {
+		if _, err := NewCipher(tt.key); !errors.Is(err, tt.err) {
+			t.Errorf("NewCipher(%q) err = %v, want %v", tt.key, err, tt.err)
+		}
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	c := newTestCipher(t, testKey)
+	tests := [][]byte{
+		{},
+		[]byte("a"),
+		[]byte("sessão do whatsapp"),
+		bytes.Repeat([]byte{0, 1, 2, 255}, 4096),
+	}
+	for _, plain := range tests {
+		data, err := c.Encrypt(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncrypted(data) {
+			t.Errorf("Encrypt(%d bytes) sem o prefixo", len(plain))
+		}
+		if len(plain) > 0 && bytes.Contains(data, plain) {
+			t.Errorf("Encrypt(%d bytes) contém o texto aberto", len(plain))
+		}
+		got, err := c.Decrypt(data)
+		if err != nil {
+			t.Fatalf("Decrypt(%d bytes): %v", len(plain), err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("Decrypt(Encrypt(%d bytes)) não confere", len(plain))
+		}
+	}
+}
+
+func TestEncryptUsesRandomNonce(t *testing.T) {
+	c := newTestCipher(t, testKey)
+	a, _ := c.Encrypt([]byte("mesmo valor"))
+	b, _ := c.Encrypt([]byte("mesmo valor"))
+	if bytes.Equal(a, b) {
+		t.Error("Encrypt gerou o mesmo resultado duas vezes")
+	}
+}
+
+func TestEncryptDeterministic(t *testing.T) {
+	c := newTestCipher(t, testKey)
+	tests := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"token-1", "token-1", true},
+		{"", "", true},
+		{"token-1", "token-2", false},
+		{"token", "token ", false},
+	}
+	for _, tt := range tests {
+		a := c.EncryptDeterministic([]byte(tt.a))
+		b := c.EncryptDeterministic([]byte(tt.b))
+		if bytes.Equal(a, b) != tt.equal {
+			t.Errorf("EncryptDeterministic(%q) == EncryptDeterministic(%q): got %v, want %v",
+				tt.a, tt.b, !tt.equal, tt.equal)
+		}
+		plain, err := c.Decrypt(a)
+		if err != nil || string(plain) != tt.a {
+			t.Errorf("Decrypt(EncryptDeterministic(%q)) = %q, %v", tt.a, plain, err)
+		}
+	}
+
+	// Outra chave gera outro resultado para o mesmo valor
+	other := newTestCipher(t, "outra-chave-mestra-de-teste")
+	if bytes.Equal(c.EncryptDeterministic([]byte("token-1")), other.EncryptDeterministic([]byte("token-1"))) {
+		t.Error("chaves diferentes geraram o mesmo resultado")
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	c := newTestCipher(t, testKey)
+	data, err := c.Encrypt([]byte("segredo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte(nil), data...)
+	tampered[len(tampered)-1] ^= 1
+	other, _ := newTestCipher(t, "outra-chave-mestra-de-teste").Encrypt([]byte("segredo"))
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"texto aberto", []byte("segredo"), ErrNotEncrypted},
+		{"vazio", nil, ErrNotEncrypted},
+		{"só o prefixo", magic, ErrWrongKey},
+		{"nonce incompleto", append(append([]byte(nil), magic...), 1, 2, 3), ErrWrongKey},
+		{"alterado", tampered, ErrWrongKey},
+		{"outra chave", other, ErrWrongKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := c.Decrypt(tt.data); !errors.Is(err, tt.err) {
+				t.Errorf("Decrypt err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestHashSecret(t *testing.T) {
+	// SHA-256 de "abc" (FIPS 180-2)
+	if got, want := HashSecret("abc"), "sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"; got != want {
+		t.Errorf("HashSecret(abc) = %s, want %s", got, want)
+	}
+	if !IsHashed(HashSecret("abc")) {
+		t.Error("IsHashed(HashSecret) = false")
+	}
+	if IsHashed("abc") {
+		t.Error("IsHashed(abc) = true")
+	}
+}
+
+func TestCompareSecret(t *testing.T) {
+	hash := HashSecret("api-key-123")
+	tests := []struct {
+		hash, secret string
+		ok           bool
+	}{
+		{hash, "api-key-123", true},
+		{hash, "api-key-124", false},
+		{hash, "API-KEY-123", false},
+		{hash, "", false},
+		{"", "api-key-123", false},
+		{"", "", false},
+		// O segredo em texto aberto não vale como hash
+		{"api-key-123", "api-key-123", false},
+	}
+	for _, tt := range tests {
+		if got := CompareSecret(tt.hash, tt.secret); got != tt.ok {
+			t.Errorf("CompareSecret(%q, %q) = %v, want %v", tt.hash, tt.secret, got, tt.ok)
+		}
+	}
+}
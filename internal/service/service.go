@@ -2,14 +2,19 @@ package service
 
 import (
 	"context"
+	"encoding/hex"
         "log"
 	"fmt"
+	"io"
 	"math/rand"
+	"net/http"
+	"path/filepath"
 	"strings"
         "os"
         "os/exec"
 	"time"
 	"wapi/internal/instance"
+	"wapi/internal/messages"
 
 	"go.mau.fi/whatsmeow"
 	waProto "go.mau.fi/whatsmeow/proto/waE2E"
@@ -34,9 +39,43 @@ func parseJID(number string) types.JID {
 	return types.NewJID(number, types.DefaultUserServer)
 }
 
-func SendText(inst *instance.Instance, to, message string) error {
+// SendResult identifica a mensagem entregue ao servidor do WhatsApp
+type SendResult struct {
+	MessageID    string    `json:"message_id"`
+	Timestamp    time.Time `json:"timestamp"`
+	RecipientJID string    `json:"recipient_jid"`
+
+	// Mensagem enviada, para o histórico
+	Message *messages.Message `json:"-"`
+}
+
+func newSendResult(inst *instance.Instance, resp whatsmeow.SendResponse, jid types.JID, msgType, body string, media *messages.Media) *SendResult {
+	var sender string
+	if inst.WAClient.Store.ID != nil {
+		sender = inst.WAClient.Store.ID.ToNonAD().String()
+	}
+	return &SendResult{
+		MessageID:    resp.ID,
+		Timestamp:    resp.Timestamp,
+		RecipientJID: jid.String(),
+		Message: &messages.Message{
+			InstanceID: inst.ID,
+			MessageID:  resp.ID,
+			ChatJID:    jid.ToNonAD().String(),
+			SenderJID:  sender,
+			Direction:  messages.Outbound,
+			Type:       msgType,
+			Body:       body,
+			Media:      media,
+			Status:     messages.StatusServerAck,
+			Timestamp:  resp.Timestamp,
+		},
+	}
+}
+
+func SendText(inst *instance.Instance, to, message string) (*SendResult, error) {
 	if !inst.WAClient.IsConnected() {
-		return fmt.Errorf("instância não conectada")
+		return nil, fmt.Errorf("instância não conectada")
 	}
 
 	jid := parseJID(to)
@@ -55,17 +94,22 @@ func SendText(inst *instance.Instance, to, message string) error {
 		Conversation: proto.String(message),
 	}
 
-	_, err := inst.WAClient.SendMessage(context.Background(), jid, msg)
+	resp, err := inst.WAClient.SendMessage(context.Background(), jid, msg)
 	if err != nil {
-		return fmt.Errorf("erro ao enviar mensagem: %w", err)
+		return nil, fmt.Errorf("erro ao enviar mensagem: %w", err)
 	}
 
-	return nil
+	return newSendResult(inst, resp, jid, "text", message, nil), nil
 }
 
-func SendMedia(inst *instance.Instance, to string, data []byte, mimetype, filename, caption string, isAudio bool) error {
+// SendMedia envia uma mídia. O callback progress (opcional) recebe a etapa atual: "converting" ou "uploading".
+func SendMedia(inst *instance.Instance, to string, data []byte, mimetype, filename, caption string, isAudio bool, progress func(stage string)) (*SendResult, error) {
+	if progress == nil {
+		progress = func(string) {}
+	}
+
 	if !inst.WAClient.IsConnected() {
-		return fmt.Errorf("instância não conectada")
+		return nil, fmt.Errorf("instância não conectada")
 	}
 
 	jid := parseJID(to)
@@ -83,6 +127,7 @@ func SendMedia(inst *instance.Instance, to string, data []byte, mimetype, filena
 	// Comprimir vídeos > 16MB automaticamente
 	if strings.HasPrefix(mimetype, "video/") {
 		log.Printf("[CONVERT] Converting video to mp4...")
+		progress("converting")
 		converted, _, convErr := compressVideo(data)
 		if convErr == nil && len(converted) > 0 {
 			data = converted
@@ -91,6 +136,7 @@ func SendMedia(inst *instance.Instance, to string, data []byte, mimetype, filena
 		}
         }
         time.Sleep(time.Duration(delay) * time.Millisecond)
+        progress("uploading")
         uploaded, err := inst.WAClient.Upload(context.Background(), data, whatsmeow.MediaImage)
         if isAudio {
                 uploaded, err = inst.WAClient.Upload(context.Background(), data, whatsmeow.MediaAudio)
@@ -104,12 +150,14 @@ func SendMedia(inst *instance.Instance, to string, data []byte, mimetype, filena
 
 	if err != nil {
         log.Printf("[ERROR] Upload failed - mimetype: %s, isAudio: %v, error: %v", mimetype, isAudio, err)
-		return fmt.Errorf("erro ao fazer upload: %w", err)
+		return nil, fmt.Errorf("erro ao fazer upload: %w", err)
 	}
 
 	var msg *waProto.Message
+	var msgType string
 
 	if isAudio {
+		msgType = "audio"
 		msg = &waProto.Message{
 			AudioMessage: &waProto.AudioMessage{
 				URL:           proto.String(uploaded.URL),
@@ -123,6 +171,7 @@ func SendMedia(inst *instance.Instance, to string, data []byte, mimetype, filena
 			},
 		}
 	} else if mimetype == "image/jpeg" || mimetype == "image/png" || mimetype == "image/webp" {
+		msgType = "image"
 		msg = &waProto.Message{
 			ImageMessage: &waProto.ImageMessage{
 				URL:           proto.String(uploaded.URL),
@@ -136,6 +185,7 @@ func SendMedia(inst *instance.Instance, to string, data []byte, mimetype, filena
 			},
 		}
 	} else if strings.HasPrefix(mimetype, "video/") {
+		msgType = "video"
 		msg = &waProto.Message{
 			VideoMessage: &waProto.VideoMessage{
 				URL:           proto.String(uploaded.URL),
@@ -149,6 +199,7 @@ func SendMedia(inst *instance.Instance, to string, data []byte, mimetype, filena
 			},
 		}
 	} else {
+		msgType = "document"
 		msg = &waProto.Message{
 			DocumentMessage: &waProto.DocumentMessage{
 				URL:           proto.String(uploaded.URL),
@@ -165,13 +216,26 @@ func SendMedia(inst *instance.Instance, to string, data []byte, mimetype, filena
 	}
         log.Printf("[DEBUG] Sending message - type: %s, size: %d, jid: %s", mimetype, len(data), jid.String())
 
-	_, err = inst.WAClient.SendMessage(context.Background(), jid, msg)
+	resp, err := inst.WAClient.SendMessage(context.Background(), jid, msg)
 	if err != nil {
-		return fmt.Errorf("erro ao enviar mídia: %w", err)
+		return nil, fmt.Errorf("erro ao enviar mídia: %w", err)
 	}
 
         log.Printf("[DEBUG] Message sent successfully")
-	return nil
+	media := &messages.Media{
+		Mimetype: mimetype,
+		Filename: filename,
+		Size:     uint64(len(data)),
+		SHA256:   hex.EncodeToString(uploaded.FileSHA256),
+	}
+	if isAudio {
+		// Áudios vão como PTT, sem legenda
+		media.Mimetype = "audio/ogg; codecs=opus"
+		caption = ""
+	}
+	result := newSendResult(inst, resp, jid, msgType, caption, media)
+	result.Message.Download = instance.DownloadInfo(msg)
+	return result, nil
 }
 
 func GetGroups(inst *instance.Instance) ([]map[string]interface{}, error) {
@@ -233,3 +297,52 @@ func compressVideo(data []byte) ([]byte, string, error) {
 	
 	return compressed, "video/mp4", nil
 }
+
+// DownloadFromURL baixa a mídia da URL e retorna dados, mimetype e filename
+func DownloadFromURL(url string) ([]byte, string, string, error) {
+	client := &http.Client{
+		Timeout: 300 * time.Second,
+	}
+	
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("erro ao baixar mídia: %w", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", fmt.Errorf("erro HTTP %d ao baixar mídia", resp.StatusCode)
+	}
+	
+	// Verificar tamanho (limite 200MB)
+	if resp.ContentLength > 200*1024*1024 {
+		return nil, "", "", fmt.Errorf("arquivo excede o limite de 200MB")
+	}
+	
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("erro ao ler dados: %w", err)
+	}
+	
+	// Pegar mimetype do header
+	mimetype := resp.Header.Get("Content-Type")
+	if mimetype == "" || mimetype == "application/octet-stream" {
+		// Fallback: detectar pelos primeiros bytes
+		mimetype = http.DetectContentType(data)
+	}
+	
+	// Extrair filename da URL
+	filename := filepath.Base(url)
+	
+	return data, mimetype, filename, nil
+}
+
+// IsAudio detecta se a mídia deve ser enviada como áudio (PTT)
+func IsAudio(mediaType, mimetype, filename string) bool {
+	return mediaType == "audio" ||
+		strings.Contains(mimetype, "audio") ||
+		strings.HasSuffix(filename, ".ogg") ||
+		strings.HasSuffix(filename, ".mp3") ||
+		strings.HasSuffix(filename, ".m4a") ||
+		strings.HasSuffix(filename, ".opus")
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSignature = errors.New("assinatura inválida ou expirada")
+
+// Local guarda as mídias em um diretório servido pela própria API em /media.
+// As URLs são assinadas com HMAC-SHA256 e expiram, como as pré-assinadas do S3.
+type Local struct {
+	dir     string
+	baseURL string
+	secret  []byte
+	expiry  time.Duration
+	now     func() time.Time
+}
+
+func NewLocal(dir, baseURL string, secret []byte, expiry time.Duration) (*Local, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("segredo das URLs de mídia não configurado")
+	}
+	// A URL vai no webhook e é aberta fora da API: precisa ser absoluta
+	base, err := url.Parse(baseURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("MEDIA_PUBLIC_URL deve ser uma URL absoluta (ex.: https://wapi.seudominio.com/media) com MEDIA_STORAGE=local")
+	}
+	if expiry <= 0 {
+		expiry = maxURLExpiry
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("erro ao criar diretório de mídias: %w", err)
+	}
+	return &Local{
+		dir:     dir,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		secret:  secret,
+		expiry:  expiry,
+		now:     time.Now,
+	}, nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	path := filepath.Join(l.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return "", fmt.Errorf("erro ao criar diretório de mídias: %w", err)
+	}
+	// Arquivo temporário + rename: um download pela metade nunca fica visível
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return "", fmt.Errorf("erro ao gravar mídia: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("erro ao gravar mídia: %w", err)
+	}
+	return l.URL(key)
+}
+
+// URL retorna a URL assinada do objeto, válida por MEDIA_URL_EXPIRY_HOURS
+func (l *Local) URL(key string) (string, error) {
+	expires := strconv.FormatInt(l.now().Add(l.expiry).Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", expires)
+	q.Set("signature", l.sign(key, expires))
+	return l.baseURL + "/" + key + "?" + q.Encode(), nil
+}
+
+// Open confere a assinatura e a validade de uma URL gerada por URL e retorna
+// o caminho do arquivo no disco
+func (l *Local) Open(key, expires, signature string) (string, error) {
+	key = strings.TrimPrefix(path.Clean("/"+key), "/")
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || l.now().Unix() > exp {
+		return "", ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(l.sign(key, expires))) {
+		return "", ErrInvalidSignature
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+// urlKey deriva do JWT_SECRET a chave das URLs de mídia, para o segredo dos
+// tokens nunca ser usado direto em outra assinatura
+func urlKey(jwtSecret string) []byte {
+	mac := hmac.New(sha256.New, []byte(jwtSecret))
+	mac.Write([]byte("wapi-media-url"))
+	return mac.Sum(nil)
+}
+
+func (l *Local) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLocal(t *testing.T, now time.Time) *Local {
+	t.Helper()
+	l, err := NewLocal(t.TempDir(), "https://wapi.example.com/media/", urlKey("segredo"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.now = func() time.Time { return now }
+	return l
+}
+
+// openURL confere uma URL gerada por Local.URL como o handler de /media faz
+func openURL(l *Local, link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	key := strings.TrimPrefix(u.Path, "/media/")
+	return l.Open(key, u.Query().Get("expires"), u.Query().Get("signature"))
+}
+
+func TestNewLocalRequiresAbsoluteURL(t *testing.T) {
+	tests := []struct {
+		baseURL string
+		ok      bool
+	}{
+		{"", false},
+		{"/media", false},
+		{"wapi.example.com/media", false},
+		{"ftp://wapi.example.com/media", false},
+		{"http://localhost:8080/media", true},
+		{"https://wapi.example.com/media", true},
+	}
+	for _, tt := range tests {
+		_, err := NewLocal(t.TempDir(), tt.baseURL, urlKey("segredo"), time.Hour)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewLocal(%q): erro = %v, esperado ok = %v", tt.baseURL, err, tt.ok)
+		}
+	}
+}
+
+func TestLocalSignedURL(t *testing.T) {
+	now := time.Date(2026, 2, 19, 10, 0, 0, 0, time.UTC)
+	l := newTestLocal(t, now)
+	link, err := l.Put(context.Background(), "inst/abc.jpg", []byte("mídia"), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(link, "https://wapi.example.com/media/inst/abc.jpg?") {
+		t.Fatalf("URL inesperada: %s", link)
+	}
+	u, _ := url.Parse(link)
+	q := u.Query()
+
+	tests := []struct {
+		name    string
+		key     string
+		expires string
+		sig     string
+		at      time.Time
+		ok      bool
+	}{
+		{"válida", "inst/abc.jpg", q.Get("expires"), q.Get("signature"), now, true},
+		{"no limite da validade", "inst/abc.jpg", q.Get("expires"), q.Get("signature"), now.Add(time.Hour), true},
+		{"expirada", "inst/abc.jpg", q.Get("expires"), q.Get("signature"), now.Add(time.Hour + time.Second), false},
+		{"validade estendida", "inst/abc.jpg", "9999999999", q.Get("signature"), now, false},
+		{"outra chave", "inst/outro.jpg", q.Get("expires"), q.Get("signature"), now, false},
+		{"assinatura vazia", "inst/abc.jpg", q.Get("expires"), "", now, false},
+		{"validade inválida", "inst/abc.jpg", "amanhã", q.Get("signature"), now, false},
+		{"caminho com ..", "inst/../inst/abc.jpg", q.Get("expires"), q.Get("signature"), now, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := tt.at
+			l.now = func() time.Time { return at }
+			path, err := l.Open(tt.key, tt.expires, tt.sig)
+			if !tt.ok {
+				if err != ErrInvalidSignature {
+					t.Errorf("erro = %v, esperado ErrInvalidSignature", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, err := os.ReadFile(path); err != nil || string(data) != "mídia" {
+				t.Errorf("arquivo %s: %q, %v", path, data, err)
+			}
+		})
+	}
+}
+
+func TestLocalOpenStaysInDir(t *testing.T) {
+	now := time.Date(2026, 2, 19, 10, 0, 0, 0, time.UTC)
+	l := newTestLocal(t, now)
+	expires := "9999999999"
+	path, err := l.Open("../../etc/passwd", expires, l.sign("etc/passwd", expires))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel, err := filepath.Rel(l.dir, path); err != nil || strings.HasPrefix(rel, "..") {
+		t.Errorf("caminho fora do diretório de mídias: %s", path)
+	}
+}
+
+func TestLocalKeyFromSecret(t *testing.T) {
+	now := time.Date(2026, 2, 19, 10, 0, 0, 0, time.UTC)
+	l := newTestLocal(t, now)
+	link, _ := l.URL("inst/abc.jpg")
+
+	other, err := NewLocal(l.dir, "https://wapi.example.com/media", urlKey("outro-segredo"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.now = l.now
+	if _, err := openURL(other, link); err != ErrInvalidSignature {
+		t.Errorf("URL aceita com outro JWT_SECRET: %v", err)
+	}
+	if string(urlKey("segredo")) == "segredo" {
+		t.Error("a chave das URLs não pode ser o JWT_SECRET")
+	}
+}
@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validade máxima de uma URL assinada (limite da assinatura V4)
+const maxURLExpiry = 7 * 24 * time.Hour
+
+// S3Config configura um bucket compatível com a API do S3 (AWS, MinIO, R2...)
+type S3Config struct {
+	Endpoint  string // ex.: https://s3.amazonaws.com ou http://minio:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle usa endpoint/bucket/chave em vez de bucket.endpoint/chave (MinIO)
+	PathStyle bool
+	// PublicURL é a base das URLs de um bucket público; vazia gera URLs assinadas
+	PublicURL string
+	URLExpiry time.Duration
+}
+
+// S3 grava as mídias com requisições assinadas (AWS Signature V4)
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY e S3_SECRET_KEY são obrigatórios")
+	}
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT inválido: %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.URLExpiry <= 0 || cfg.URLExpiry > maxURLExpiry {
+		cfg.URLExpiry = maxURLExpiry
+	}
+	cfg.PublicURL = strings.TrimRight(cfg.PublicURL, "/")
+	return &S3{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 60 * time.Second},
+		now:      time.Now,
+	}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if err := s.do(req, data); err != nil {
+		return "", fmt.Errorf("erro ao gravar mídia no S3: %w", err)
+	}
+	return s.URL(key)
+}
+
+// URL retorna a URL pública do objeto ou uma URL assinada com validade
+// S3_URL_EXPIRY_HOURS
+func (s *S3) URL(key string) (string, error) {
+	if s.cfg.PublicURL != "" {
+		return s.cfg.PublicURL + "/" + key, nil
+	}
+	return s.presign(http.MethodGet, s.objectURL(key), s.cfg.URLExpiry), nil
+}
+
+// EnsureBucket cria o bucket se ele ainda não existir
+func (s *S3) EnsureBucket(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.bucketURL().String(), nil)
+	if err != nil {
+		return err
+	}
+	if err := s.do(req, nil); err == nil {
+		return nil
+	}
+
+	var body []byte
+	if s.cfg.Region != "us-east-1" {
+		body = []byte(`<CreateBucketConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><LocationConstraint>` +
+			s.cfg.Region + `</LocationConstraint></CreateBucketConfiguration>`)
+	}
+	req, err = http.NewRequestWithContext(ctx, http.MethodPut, s.bucketURL().String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	return s.do(req, body)
+}
+
+// do assina e executa a requisição; respostas fora de 2xx viram erro
+func (s *S3) do(req *http.Request, body []byte) error {
+	s.sign(req, body)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *S3) bucketURL() *url.URL {
+	u := *s.endpoint
+	if s.cfg.PathStyle {
+		u.Path = u.Path + "/" + s.cfg.Bucket
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	return &u
+}
+
+func (s *S3) objectURL(key string) *url.URL {
+	u := s.bucketURL()
+	u.Path = u.Path + "/" + key
+	return u
+}
+
+// sign adiciona os cabeçalhos da assinatura V4 à requisição
+func (s *S3) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	scope := s.scope(now)
+	signature := s.signature(now, req.Method, req.URL, "", canonicalHeaders, signedHeaders, payloadHash)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+// presign gera uma URL com a assinatura V4 na query string
+func (s *S3) presign(method string, u *url.URL, expiry time.Duration) string {
+	now := s.now().UTC()
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.cfg.AccessKey+"/"+s.scope(now))
+	query.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expiry.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalQuery := canonicalQueryString(query)
+	signature := s.signature(now, method, u, canonicalQuery, "host:"+u.Host+"\n", "host", "UNSIGNED-PAYLOAD")
+
+	signed := *u
+	signed.RawQuery = canonicalQuery + "&X-Amz-Signature=" + signature
+	return signed.String()
+}
+
+func (s *S3) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+func (s *S3) signature(t time.Time, method string, u *url.URL, query, headers, signedHeaders, payloadHash string) string {
+	canonicalRequest := canonicalRequest(method, u, query, headers, signedHeaders, payloadHash)
+	stringToSign := "AWS4-HMAC-SHA256\n" + t.Format("20060102T150405Z") + "\n" + s.scope(t) + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func canonicalRequest(method string, u *url.URL, query, headers, signedHeaders, payloadHash string) string {
+	return strings.Join([]string{
+		method,
+		canonicalPath(u.Path),
+		query,
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+}
+
+func canonicalPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		segments[i] = uriEncode(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+func canonicalQueryString(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, uriEncode(k)+"="+uriEncode(values.Get(k)))
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode codifica como a assinatura V4 exige (RFC 3986, espaço como %20)
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
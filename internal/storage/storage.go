@@ -0,0 +1,130 @@
+// Package storage guarda as mídias baixadas das mensagens recebidas
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"path"
+	"strings"
+	"time"
+	"wapi/config"
+)
+
+// Backends de armazenamento (MEDIA_STORAGE)
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// Storage grava objetos e gera a URL de acesso a eles
+type Storage interface {
+	// Put grava o objeto e retorna a URL de acesso
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+	// URL retorna a URL de acesso a um objeto já gravado
+	URL(key string) (string, error)
+}
+
+// Global é o backend configurado. Fica nil quando o download automático de
+// mídias está desligado.
+var Global Storage
+
+// Tipos de mensagem baixados e tamanho máximo aceito
+var (
+	downloadTypes map[string]bool
+	maxSize       uint64
+)
+
+// Init configura o backend a partir das variáveis MEDIA_* e S3_*
+func Init() error {
+	downloadTypes = map[string]bool{}
+	for _, t := range strings.Split(config.App.MediaDownloadTypes, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			downloadTypes[t] = true
+		}
+	}
+	maxSize = uint64(config.App.MediaMaxSizeMB) << 20
+
+	var err error
+	switch config.App.MediaStorage {
+	case "":
+		Global = nil
+	case BackendLocal:
+		Global, err = NewLocal(config.App.MediaDir, config.App.MediaPublicURL, urlKey(config.App.JWTSecret),
+			time.Duration(config.App.MediaURLExpiryHours)*time.Hour)
+	case BackendS3:
+		var s3 *S3
+		s3, err = NewS3(S3Config{
+			Endpoint:  config.App.S3Endpoint,
+			Region:    config.App.S3Region,
+			Bucket:    config.App.S3Bucket,
+			AccessKey: config.App.S3AccessKey,
+			SecretKey: config.App.S3SecretKey,
+			PathStyle: config.App.S3PathStyle,
+			PublicURL: config.App.S3PublicURL,
+			URLExpiry: time.Duration(config.App.S3URLExpiryHours) * time.Hour,
+		})
+		if err == nil {
+			// O bucket é criado se não existir (útil com o MinIO local)
+			if err := s3.EnsureBucket(context.Background()); err != nil {
+				log.Printf("Aviso: bucket %s indisponível: %v", config.App.S3Bucket, err)
+			}
+			Global = s3
+		}
+	default:
+		err = fmt.Errorf("MEDIA_STORAGE inválido: %q (use local ou s3)", config.App.MediaStorage)
+	}
+	return err
+}
+
+// Enabled indica se o download automático de mídias está ligado
+func Enabled() bool {
+	return Global != nil
+}
+
+// Accepts indica se a mídia do tipo e tamanho informados deve ser guardada.
+// Tamanho zero (desconhecido) é aceito e conferido depois do download.
+func Accepts(msgType string, size uint64) bool {
+	if !Enabled() || !downloadTypes[msgType] {
+		return false
+	}
+	return maxSize == 0 || size <= maxSize
+}
+
+// Key monta a chave do objeto: instância, SHA-256 do conteúdo e extensão.
+// O hash deixa as URLs imprevisíveis e evita cópias repetidas da mesma mídia.
+func Key(instanceID, sha256Hex, mimetype string) string {
+	return path.Join(instanceID, sha256Hex+Extension(mimetype))
+}
+
+// Extensões dos tipos mais comuns no WhatsApp, que nem sempre estão na
+// tabela de tipos do sistema
+var extensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/webp":      ".webp",
+	"image/gif":       ".gif",
+	"video/mp4":       ".mp4",
+	"video/3gpp":      ".3gp",
+	"audio/ogg":       ".ogg",
+	"audio/mpeg":      ".mp3",
+	"audio/mp4":       ".m4a",
+	"audio/aac":       ".aac",
+	"application/pdf": ".pdf",
+}
+
+// Extension retorna a extensão de arquivo do mimetype (.bin se desconhecido)
+func Extension(mimetype string) string {
+	mediaType, _, err := mime.ParseMediaType(mimetype)
+	if err != nil {
+		return ".bin"
+	}
+	if ext, ok := extensions[mediaType]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
@@ -0,0 +1,123 @@
+package transfer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Formato do arquivo: magic | salt (16) | nonce (12) | AES-256-GCM(gzip(JSON)).
+// A chave é derivada da passphrase com scrypt.
+var magic = []byte("WAPIEXP1")
+
+const (
+	saltSize   = 16
+	scryptN    = 1 << 15
+	scryptR    = 8
+	scryptP    = 1
+	keySize    = 32
+	minPassLen = 8
+)
+
+var (
+	ErrInvalidArchive = errors.New("arquivo de exportação inválido")
+	ErrWrongPassword  = errors.New("passphrase incorreta ou arquivo corrompido")
+	ErrWeakPassphrase = fmt.Errorf("passphrase deve ter ao menos %d caracteres", minPassLen)
+)
+
+// ValidatePassphrase confere o tamanho mínimo da passphrase de exportação
+func ValidatePassphrase(passphrase string) error {
+	if len(passphrase) < minPassLen {
+		return ErrWeakPassphrase
+	}
+	return nil
+}
+
+// seal serializa, comprime e cifra o conteúdo com a passphrase
+func seal(passphrase string, v interface{}) ([]byte, error) {
+	if err := ValidatePassphrase(passphrase); err != nil {
+		return nil, err
+	}
+
+	var plain bytes.Buffer
+	gz := gzip.NewWriter(&plain)
+	if err := json.NewEncoder(gz).Encode(v); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(magic)+saltSize+len(nonce)+plain.Len()+gcm.Overhead())
+	out = append(out, magic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	// O cabeçalho entra como dado autenticado
+	header := append([]byte{}, out...)
+	return gcm.Seal(out, nonce, plain.Bytes(), header), nil
+}
+
+// open decifra e desserializa um arquivo gerado por seal
+func open(passphrase string, data []byte, v interface{}) error {
+	if len(data) < len(magic)+saltSize || !bytes.Equal(data[:len(magic)], magic) {
+		return ErrInvalidArchive
+	}
+	salt := data[len(magic) : len(magic)+saltSize]
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	headerSize := len(magic) + saltSize + gcm.NonceSize()
+	if len(data) < headerSize+gcm.Overhead() {
+		return ErrInvalidArchive
+	}
+	nonce := data[len(magic)+saltSize : headerSize]
+	plain, err := gcm.Open(nil, nonce, data[headerSize:], data[:headerSize])
+	if err != nil {
+		return ErrWrongPassword
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return ErrInvalidArchive
+	}
+	defer gz.Close()
+	raw, err := io.ReadAll(gz)
+	if err != nil {
+		return ErrInvalidArchive
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
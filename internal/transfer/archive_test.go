@@ -0,0 +1,110 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+const testPassphrase = "passphrase-de-teste"
+
+func TestValidatePassphrase(t *testing.T) {
+	tests := []struct {
+		passphrase string
+		err        error
+	}{
+		{"", ErrWeakPassphrase},
+		{"curta", ErrWeakPassphrase},
+		{"1234567", ErrWeakPassphrase},
+		{"12345678", nil},
+		{testPassphrase, nil},
+	}
+	for _, tt := range tests {
+		if err := ValidatePassphrase(tt.passphrase); !errors.Is(err, tt.err) {
+			t.Errorf("ValidatePassphrase(%q) = %v, want %v", tt.passphrase, err, tt.err)
+		}
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	bundle := Bundle{
+		Version:    bundleVersion,
+		ExportedAt: time.Date(2026, 2, 19, 13, 0, 0, 0, time.UTC),
+		Instance: InstanceData{
+			ID:         "4f0c6f1e-8d2a-4c55-9d0b-2f1b7e0c9a11",
+			Name:       "vendas",
+			APIKeyHash: "sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+			WebhookURL: "https://example.com/webhook",
+			AutoRead:   true,
+		},
+	}
+	data, err := seal(testPassphrase, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, magic) {
+		t.Error("arquivo sem o prefixo")
+	}
+	if bytes.Contains(data, []byte("vendas")) {
+		t.Error("arquivo contém o conteúdo em texto aberto")
+	}
+
+	var got Bundle
+	if err := open(testPassphrase, data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != bundle.Version || !got.ExportedAt.Equal(bundle.ExportedAt) || got.Instance != bundle.Instance {
+		t.Errorf("open = %+v, want %+v", got, bundle)
+	}
+
+	tampered := func(i int) []byte {
+		d := append([]byte(nil), data...)
+		d[i] ^= 1
+		return d
+	}
+	headerSize := len(magic) + saltSize + 12
+	tests := []struct {
+		name       string
+		passphrase string
+		data       []byte
+		err        error
+	}{
+		{"passphrase errada", "outra-passphrase", data, ErrWrongPassword},
+		{"vazio", testPassphrase, nil, ErrInvalidArchive},
+		{"prefixo errado", testPassphrase, tampered(0), ErrInvalidArchive},
+		{"só o cabeçalho", testPassphrase, data[:headerSize], ErrInvalidArchive},
+		{"salt alterado", testPassphrase, tampered(len(magic)), ErrWrongPassword},
+		{"nonce alterado", testPassphrase, tampered(len(magic) + saltSize), ErrWrongPassword},
+		{"conteúdo alterado", testPassphrase, tampered(len(data) - 1), ErrWrongPassword},
+		{"truncado", testPassphrase, data[:len(data)-1], ErrWrongPassword},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b Bundle
+			if err := open(tt.passphrase, tt.data, &b); !errors.Is(err, tt.err) {
+				t.Errorf("open err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSealUsesFreshSalt(t *testing.T) {
+	a, err := seal(testPassphrase, map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := seal(testPassphrase, map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a[:len(magic)+saltSize], b[:len(magic)+saltSize]) {
+		t.Error("dois arquivos com o mesmo salt")
+	}
+}
+
+func TestSealRejectsWeakPassphrase(t *testing.T) {
+	if _, err := seal("curta", Bundle{}); !errors.Is(err, ErrWeakPassphrase) {
+		t.Errorf("seal err = %v, want ErrWeakPassphrase", err)
+	}
+}
@@ -0,0 +1,145 @@
+// Package transfer exporta e importa instâncias (configuração e sessão do
+// WhatsApp) para mover um número entre servidores sem parear de novo.
+package transfer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"wapi/internal/instance"
+	"wapi/internal/secure"
+	"wapi/internal/webhook"
+	"wapi/internal/whatsapp"
+	"wapi/store/postgres"
+)
+
+// Versões do arquivo de exportação. A 1 levava a API key em texto claro; a
+// partir da 2 vai só o hash.
+const (
+	bundleVersionPlainKey = 1
+	bundleVersion         = 2
+)
+
+var ErrInstanceExists = errors.New("já existe uma instância com este id, nome ou api key")
+
+// InstanceData é a linha de instances exportada
+type InstanceData struct {
+	ID                   string `json:"id"`
+	Name                 string `json:"name"`
+	APIKeyHash           string `json:"api_key"` // hash, a chave em si não é exportada
+	WebhookURL           string `json:"webhook_url"`
+	WebhookSecret        string `json:"webhook_secret"`
+	TranscriptionEnabled bool   `json:"transcription_enabled"`
+	TypingDelayMin       int    `json:"typing_delay_min"`
+	TypingDelayMax       int    `json:"typing_delay_max"`
+	HistoryDays          int    `json:"history_days"`
+	EmitFromMe           bool   `json:"emit_from_me"`
+	AutoRead             bool   `json:"auto_read"`
+}
+
+// Bundle é o conteúdo cifrado do arquivo de exportação
+type Bundle struct {
+	Version    int                   `json:"version"`
+	ExportedAt time.Time             `json:"exported_at"`
+	Instance   InstanceData          `json:"instance"`
+	Webhooks   []webhook.Endpoint    `json:"webhooks"`
+	Session    *whatsapp.SessionDump `json:"session"`
+}
+
+// Export gera o arquivo cifrado com a instância, seus webhooks e o device
+// store. A instância deve estar desconectada, com a sessão já gravada.
+func Export(instanceID, passphrase string) ([]byte, error) {
+	if err := ValidatePassphrase(passphrase); err != nil {
+		return nil, err
+	}
+
+	var data InstanceData
+	err := postgres.DB.QueryRow(
+		`SELECT id, name, api_key, webhook_url, webhook_secret, transcription_enabled, typing_delay_min, typing_delay_max, history_days, emit_from_me, auto_read
+		FROM instances WHERE id = $1`, instanceID,
+	).Scan(&data.ID, &data.Name, &data.APIKeyHash, &data.WebhookURL, &data.WebhookSecret,
+		&data.TranscriptionEnabled, &data.TypingDelayMin, &data.TypingDelayMax, &data.HistoryDays, &data.EmitFromMe, &data.AutoRead)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler instância: %w", err)
+	}
+
+	endpoints, err := webhook.ListEndpoints(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := whatsapp.ExportSession(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return seal(passphrase, &Bundle{
+		Version:    bundleVersion,
+		ExportedAt: time.Now(),
+		Instance:   data,
+		Webhooks:   endpoints,
+		Session:    session,
+	})
+}
+
+// Import decifra o arquivo, grava a instância e o device store e retorna os
+// dados da instância para que ela seja carregada e conectada
+func Import(archive []byte, passphrase string) (*InstanceData, error) {
+	// Campos ausentes (arquivos gerados antes de a coluna existir) ficam com
+	// o padrão da coluna em vez do zero
+	b := Bundle{Instance: InstanceData{
+		TypingDelayMin: 1000,
+		TypingDelayMax: 3000,
+		HistoryDays:    instance.DefaultHistoryDays,
+	}}
+	if err := open(passphrase, archive, &b); err != nil {
+		return nil, err
+	}
+	if (b.Version != bundleVersion && b.Version != bundleVersionPlainKey) || b.Session == nil || b.Instance.ID == "" {
+		return nil, ErrInvalidArchive
+	}
+	if b.Version == bundleVersionPlainKey {
+		b.Instance.APIKeyHash = secure.HashSecret(b.Instance.APIKeyHash)
+	} else if !secure.IsHashed(b.Instance.APIKeyHash) {
+		return nil, ErrInvalidArchive
+	}
+
+	var exists bool
+	err := postgres.DB.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM instances WHERE id = $1 OR name = $2 OR api_key = $3)`,
+		b.Instance.ID, b.Instance.Name, b.Instance.APIKeyHash,
+	).Scan(&exists)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("erro ao verificar instância existente: %w", err)
+	}
+	if exists {
+		return nil, ErrInstanceExists
+	}
+
+	d := b.Instance
+	_, err = postgres.DB.Exec(
+		`INSERT INTO instances (id, name, api_key, webhook_url, webhook_secret, transcription_enabled, typing_delay_min, typing_delay_max, history_days, emit_from_me, auto_read)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		d.ID, d.Name, d.APIKeyHash, d.WebhookURL, d.WebhookSecret, d.TranscriptionEnabled, d.TypingDelayMin, d.TypingDelayMax, d.HistoryDays, d.EmitFromMe, d.AutoRead,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao salvar instância: %w", err)
+	}
+
+	// Os webhooks ganham ids novos; o histórico de entregas fica na origem
+	for _, e := range b.Webhooks {
+		e.InstanceID = d.ID
+		if err := webhook.CreateEndpoint(&e); err != nil {
+			postgres.DB.Exec(`DELETE FROM instances WHERE id = $1`, d.ID)
+			return nil, err
+		}
+	}
+
+	if err := whatsapp.ImportSession(d.ID, b.Session); err != nil {
+		whatsapp.DeleteSession(d.ID)
+		postgres.DB.Exec(`DELETE FROM instances WHERE id = $1`, d.ID)
+		return nil, fmt.Errorf("erro ao importar sessão: %w", err)
+	}
+	return &d, nil
+}
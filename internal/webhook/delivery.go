@@ -0,0 +1,296 @@
+package webhook
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"wapi/config"
+	"wapi/store/postgres"
+)
+
+// Estados de uma entrega
+const (
+	StatusPending   = "pending"
+	StatusSending   = "sending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+var ErrNotFound = errors.New("entrega não encontrada")
+
+type Delivery struct {
+	ID             string          `json:"id"`
+	InstanceID     string          `json:"instance_id"`
+	WebhookID      string          `json:"webhook_id,omitempty"`
+	Global         bool            `json:"global"`
+	Replay         bool            `json:"replay"`
+	URL            string          `json:"url"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastStatusCode int             `json:"last_status_code"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+type DeadLetter struct {
+	ID             string          `json:"id"`
+	DeliveryID     string          `json:"delivery_id"`
+	InstanceID     string          `json:"instance_id"`
+	WebhookID      string          `json:"webhook_id,omitempty"`
+	Global         bool            `json:"global"`
+	URL            string          `json:"url"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Attempts       int             `json:"attempts"`
+	LastStatusCode int             `json:"last_status_code"`
+	LastError      string          `json:"last_error"`
+	CreatedAt      time.Time       `json:"created_at"`
+	ReplayedAt     *time.Time      `json:"replayed_at,omitempty"`
+}
+
+const deliveryColumns = `id, instance_id, COALESCE(webhook_id::text, ''), global, replay, url, event, payload, status, attempts,
+	last_status_code, last_error, created_at, delivered_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDelivery(row rowScanner) (*Delivery, error) {
+	var d Delivery
+	var deliveredAt sql.NullTime
+	err := row.Scan(&d.ID, &d.InstanceID, &d.WebhookID, &d.Global, &d.Replay, &d.URL, &d.Event, &d.Payload, &d.Status,
+		&d.Attempts, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &deliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	if deliveredAt.Valid {
+		d.DeliveredAt = &deliveredAt.Time
+	}
+	return &d, nil
+}
+
+// target é o destino de uma entrega: a URL legada da instância, um webhook
+// cadastrado (WebhookID) ou o webhook global do servidor
+type target struct {
+	WebhookID string
+	Global    bool
+	URL       string
+}
+
+// insertDelivery enfileira uma entrega. replay indica um reenvio da dead-letter.
+func insertDelivery(instanceID string, t target, event string, payload []byte, replay bool) (string, error) {
+	var id string
+	err := postgres.DB.QueryRow(
+		`INSERT INTO webhook_deliveries (instance_id, webhook_id, global, replay, url, event, payload, max_attempts)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		instanceID, t.WebhookID, t.Global, replay, t.URL, event, payload, config.App.WebhookMaxAttempts,
+	).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("erro ao registrar entrega de webhook: %w", err)
+	}
+	return id, nil
+}
+
+// claimNext reserva a próxima entrega pronta para envio
+func claimNext() (*Delivery, error) {
+	d, err := scanDelivery(postgres.DB.QueryRow(
+		`UPDATE webhook_deliveries SET status = 'sending', attempts = attempts + 1, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + deliveryColumns,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return d, err
+}
+
+func markDelivered(d *Delivery, statusCode int) error {
+	_, err := postgres.DB.Exec(
+		`UPDATE webhook_deliveries SET status = 'delivered', last_status_code = $1, last_error = '',
+			delivered_at = NOW(), updated_at = NOW()
+		WHERE id = $2`,
+		statusCode, d.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao marcar entrega %s como entregue: %w", d.ID, err)
+	}
+	return nil
+}
+
+// markFailed reagenda a entrega com backoff exponencial ou a move para a
+// dead-letter quando as tentativas se esgotam. Se a dead-letter não puder ser
+// gravada, nada é alterado e a entrega volta para a fila no próximo start.
+func markFailed(d *Delivery, statusCode int, deliveryErr error) (dead bool, err error) {
+	var maxAttempts int
+	err = postgres.DB.QueryRow(`SELECT max_attempts FROM webhook_deliveries WHERE id = $1`, d.ID).Scan(&maxAttempts)
+	if err != nil {
+		return false, fmt.Errorf("erro ao buscar entrega %s: %w", d.ID, err)
+	}
+
+	if d.Attempts < maxAttempts {
+		_, err = postgres.DB.Exec(
+			`UPDATE webhook_deliveries SET status = 'pending', last_status_code = $1, last_error = $2,
+				next_attempt_at = $3, updated_at = NOW()
+			WHERE id = $4`,
+			statusCode, deliveryErr.Error(), time.Now().Add(backoff(d.Attempts)), d.ID,
+		)
+		if err != nil {
+			return false, fmt.Errorf("erro ao reagendar entrega %s: %w", d.ID, err)
+		}
+		return false, nil
+	}
+
+	tx, err := postgres.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("erro ao mover entrega %s para dead-letter: %w", d.ID, err)
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(
+		`UPDATE webhook_deliveries SET status = 'dead', last_status_code = $1, last_error = $2, updated_at = NOW()
+		WHERE id = $3`,
+		statusCode, deliveryErr.Error(), d.ID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("erro ao mover entrega %s para dead-letter: %w", d.ID, err)
+	}
+	_, err = tx.Exec(
+		`INSERT INTO webhook_dead_letters (delivery_id, instance_id, webhook_id, global, url, event, payload, attempts,
+			last_status_code, last_error)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, $6, $7, $8, $9, $10)`,
+		d.ID, d.InstanceID, d.WebhookID, d.Global, d.URL, d.Event, []byte(d.Payload), d.Attempts, statusCode,
+		deliveryErr.Error(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("erro ao mover entrega %s para dead-letter: %w", d.ID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("erro ao mover entrega %s para dead-letter: %w", d.ID, err)
+	}
+	return true, nil
+}
+
+// Recover devolve para a fila as entregas interrompidas por um restart
+func Recover() error {
+	_, err := postgres.DB.Exec(
+		`UPDATE webhook_deliveries SET status = 'pending', updated_at = NOW() WHERE status = 'sending'`,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao recuperar entregas de webhook: %w", err)
+	}
+	return nil
+}
+
+// purgeDelivered remove o histórico de entregas bem-sucedidas antigas
+func purgeDelivered(olderThan time.Duration) {
+	_, err := postgres.DB.Exec(
+		`DELETE FROM webhook_deliveries WHERE status = 'delivered' AND delivered_at < $1`,
+		time.Now().Add(-olderThan),
+	)
+	if err != nil {
+		log.Printf("[WEBHOOK] Erro ao limpar entregas antigas: %v", err)
+	}
+}
+
+// ListDeliveries lista as entregas mais recentes da instância, opcionalmente filtradas por status
+func ListDeliveries(instanceID, status string, limit int) ([]Delivery, error) {
+	rows, err := postgres.DB.Query(
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE instance_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC LIMIT $3`,
+		instanceID, status, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao listar entregas: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			continue
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, nil
+}
+
+// ListDeadLetters lista as entregas esgotadas da instância
+func ListDeadLetters(instanceID string, includeReplayed bool, limit int) ([]DeadLetter, error) {
+	rows, err := postgres.DB.Query(
+		`SELECT id, delivery_id, instance_id, COALESCE(webhook_id::text, ''), global, url, event, payload, attempts,
+			last_status_code, last_error, created_at, replayed_at
+		FROM webhook_dead_letters
+		WHERE instance_id = $1 AND ($2 OR replayed_at IS NULL)
+		ORDER BY created_at DESC LIMIT $3`,
+		instanceID, includeReplayed, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao listar dead-letters: %w", err)
+	}
+	defer rows.Close()
+
+	letters := []DeadLetter{}
+	for rows.Next() {
+		var dl DeadLetter
+		var replayedAt sql.NullTime
+		if err := rows.Scan(&dl.ID, &dl.DeliveryID, &dl.InstanceID, &dl.WebhookID, &dl.Global, &dl.URL, &dl.Event,
+			&dl.Payload, &dl.Attempts, &dl.LastStatusCode, &dl.LastError, &dl.CreatedAt, &replayedAt); err != nil {
+			continue
+		}
+		if replayedAt.Valid {
+			dl.ReplayedAt = &replayedAt.Time
+		}
+		letters = append(letters, dl)
+	}
+	return letters, nil
+}
+
+// Replay reenfileira uma entrega da dead-letter e retorna o ID da nova entrega
+func Replay(instanceID, deadLetterID string) (string, error) {
+	var t target
+	var event string
+	var payload []byte
+	err := postgres.DB.QueryRow(
+		`SELECT COALESCE(webhook_id::text, ''), global, url, event, payload FROM webhook_dead_letters
+		WHERE id = $1 AND instance_id = $2`,
+		deadLetterID, instanceID,
+	).Scan(&t.WebhookID, &t.Global, &t.URL, &event, &payload)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("erro ao buscar dead-letter: %w", err)
+	}
+
+	id, err := insertDelivery(instanceID, t, event, payload, true)
+	if err != nil {
+		return "", err
+	}
+	if _, err := postgres.DB.Exec(`UPDATE webhook_dead_letters SET replayed_at = NOW() WHERE id = $1`, deadLetterID); err != nil {
+		log.Printf("[WEBHOOK] Erro ao marcar dead-letter %s como reenviada: %v", deadLetterID, err)
+	}
+	Global.notify()
+	return id, nil
+}
+
+func backoff(attempt int) time.Duration {
+	base := time.Duration(config.App.WebhookBackoffSeconds) * time.Second
+	d := base << (attempt - 1)
+	if d > time.Hour || d <= 0 {
+		d = time.Hour
+	}
+	return d
+}
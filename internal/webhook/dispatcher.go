@@ -0,0 +1,235 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"wapi/config"
+	"wapi/store/postgres"
+)
+
+const (
+	pollInterval    = 2 * time.Second
+	purgeInterval   = time.Hour
+	deliveredMaxAge = 7 * 24 * time.Hour
+)
+
+// Dispatcher entrega os webhooks registrados em webhook_deliveries
+type Dispatcher struct {
+	client *http.Client
+	wake   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// OnDelivered é chamado (em outra goroutine) a cada entrega confirmada pelo destino
+	OnDelivered func(*Delivery)
+}
+
+var Global = &Dispatcher{
+	wake: make(chan struct{}, 1),
+}
+
+// Start inicia os workers de entrega
+func (d *Dispatcher) Start() {
+	d.client = &http.Client{Timeout: time.Duration(config.App.WebhookTimeoutSeconds) * time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+
+	workers := config.App.WebhookWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go d.run(ctx)
+	}
+	go d.purge(ctx)
+}
+
+// Stop encerra os workers aguardando as entregas em andamento
+func (d *Dispatcher) Stop() {
+	if d.cancel == nil {
+		return
+	}
+	d.cancel()
+	d.wg.Wait()
+}
+
+// LegacyEvent é o único evento entregue na URL legada da instância
+const LegacyEvent = "messages.upsert"
+
+// Dispatch registra uma entrega do evento para cada destino da instância: a URL
+// legada (instances.webhook_url, que recebe só as mensagens, como antes dos
+// webhooks cadastrados), os webhooks que assinam o evento e o webhook global
+// do servidor
+func Dispatch(instanceID, legacyURL, event string, payload []byte) error {
+	endpoints, err := subscribedEndpoints(instanceID, event)
+	if err != nil {
+		return fmt.Errorf("erro ao buscar webhooks: %w", err)
+	}
+
+	var targets []target
+	if legacyURL != "" && event == LegacyEvent {
+		targets = append(targets, target{URL: legacyURL})
+	}
+	for _, e := range endpoints {
+		targets = append(targets, target{WebhookID: e.ID, URL: e.URL})
+	}
+	if g := GetGlobal(); g.Enabled && g.URL != "" && matchEvent(g.Events, event) {
+		targets = append(targets, target{Global: true, URL: g.URL})
+	}
+
+	for _, t := range targets {
+		if _, err := insertDelivery(instanceID, t, event, payload, false); err != nil {
+			return err
+		}
+	}
+	if len(targets) > 0 {
+		Global.notify()
+	}
+	return nil
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) run(ctx context.Context) {
+	defer d.wg.Done()
+	for {
+		delivery, err := claimNext()
+		if err != nil {
+			log.Printf("[WEBHOOK] Erro ao buscar entrega: %v", err)
+		} else if delivery != nil {
+			d.deliver(delivery)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(delivery *Delivery) {
+	statusCode, err := d.post(delivery)
+	if err == nil {
+		if err := markDelivered(delivery, statusCode); err != nil {
+			log.Printf("[WEBHOOK] %v", err)
+		}
+		if d.OnDelivered != nil {
+			go d.OnDelivered(delivery)
+		}
+		return
+	}
+
+	dead, dbErr := markFailed(delivery, statusCode, err)
+	if dbErr != nil {
+		log.Printf("[WEBHOOK] %v", dbErr)
+		return
+	}
+	if dead {
+		log.Printf("[WEBHOOK] Entrega %s (%s) movida para dead-letter após %d tentativa(s): %v",
+			delivery.ID, delivery.Event, delivery.Attempts, err)
+	} else {
+		log.Printf("[WEBHOOK] Entrega %s (%s) falhou (tentativa %d): %v",
+			delivery.ID, delivery.Event, delivery.Attempts, err)
+	}
+}
+
+func (d *Dispatcher) post(delivery *Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("erro ao criar request: %w", err)
+	}
+	secret := ""
+	switch {
+	case delivery.Global:
+		g := GetGlobal()
+		for k, v := range g.Headers {
+			req.Header.Set(k, v)
+		}
+		secret = g.Secret
+	case delivery.WebhookID != "":
+		for k, v := range endpointHeaders(delivery.WebhookID) {
+			req.Header.Set(k, v)
+		}
+		secret = instanceSecret(delivery.InstanceID)
+	default:
+		secret = instanceSecret(delivery.InstanceID)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Wapi-Event", delivery.Event)
+	req.Header.Set("X-Wapi-Delivery", delivery.ID)
+	if secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Wapi-Timestamp", timestamp)
+		req.Header.Set("X-Wapi-Signature", "sha256="+Sign(secret, timestamp, delivery.Payload))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("erro ao chamar webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook respondeu HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign calcula a assinatura HMAC-SHA256 de "<timestamp>.<body>". O receptor deve
+// recalcular a assinatura e rejeitar timestamps muito antigos para evitar replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret gera um novo segredo de assinatura de webhook
+func NewSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// instanceSecret busca o segredo atual da instância, para que uma rotação
+// valha também para as entregas que ainda estão em retry
+func instanceSecret(instanceID string) string {
+	var secret string
+	postgres.DB.QueryRow(`SELECT webhook_secret FROM instances WHERE id = $1`, instanceID).Scan(&secret)
+	return secret
+}
+
+func (d *Dispatcher) purge(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purgeDelivered(deliveredMaxAge)
+		}
+	}
+}
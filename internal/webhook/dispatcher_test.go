@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"messages.upsert"}`)
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		want      string
+	}{
+		{"padrão", "whsec_teste", "1771506000", body,
+			"f5e47824b4b5a6cb382cc04fb9d9752c18f3389191ecfd9739e77069ea62a38a"},
+		{"outro timestamp", "whsec_teste", "1771506001", body,
+			"07081e0fbb1eabf5eb207fdedd991b23bfd25a3f6b1ca005c14c8fda7486e53c"},
+		{"outro segredo", "outro", "1771506000", body,
+			"1a588e1d91d7222a08c8480a6c047571f73f0d3707a9a5a343965f5fca1f3ca5"},
+		{"corpo vazio", "whsec_teste", "1771506000", nil,
+			"19865bd9af3ee985f65f71950b5b3478c2a31b2dfa32ae73303ae286d3a8e7a6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, tt.timestamp, tt.body); got != tt.want {
+				t.Errorf("Sign = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostSignsGlobalDelivery(t *testing.T) {
+	payload := `{"event":"messages.upsert","data":{"message":"oi"}}`
+	var got *http.Request
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{"com segredo", "whsec_teste"},
+		{"sem segredo", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			global.mu.Lock()
+			saved := global.cfg
+			global.cfg = GlobalConfig{URL: srv.URL, Secret: tt.secret, Enabled: true,
+				Headers: map[string]string{"X-Extra": "1"}}
+			global.mu.Unlock()
+			defer func() {
+				global.mu.Lock()
+				global.cfg = saved
+				global.mu.Unlock()
+			}()
+
+			d := &Dispatcher{client: srv.Client()}
+			status, err := d.post(&Delivery{ID: "d1", Global: true, URL: srv.URL,
+				Event: "messages.upsert", Payload: []byte(payload)})
+			if err != nil || status != http.StatusOK {
+				t.Fatalf("post = %d, %v", status, err)
+			}
+			if string(gotBody) != payload {
+				t.Errorf("corpo = %s", gotBody)
+			}
+			if got.Header.Get("X-Wapi-Event") != "messages.upsert" || got.Header.Get("X-Extra") != "1" {
+				t.Errorf("headers = %v", got.Header)
+			}
+
+			signature := got.Header.Get("X-Wapi-Signature")
+			timestamp := got.Header.Get("X-Wapi-Timestamp")
+			if tt.secret == "" {
+				if signature != "" || timestamp != "" {
+					t.Errorf("entrega sem segredo assinada: %s %s", signature, timestamp)
+				}
+				return
+			}
+			// Valida como o README orienta o receptor
+			want := "sha256=" + Sign(tt.secret, timestamp, gotBody)
+			if timestamp == "" || !hmac.Equal([]byte(signature), []byte(want)) {
+				t.Errorf("assinatura = %s (timestamp %q), want %s", signature, timestamp, want)
+			}
+			if !strings.HasPrefix(signature, "sha256=") || len(signature) != len("sha256=")+64 {
+				t.Errorf("assinatura mal formada: %s", signature)
+			}
+		})
+	}
+}
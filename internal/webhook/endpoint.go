@@ -0,0 +1,229 @@
+package webhook
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"wapi/store/postgres"
+
+	"github.com/lib/pq"
+)
+
+var ErrEndpointNotFound = errors.New("webhook não encontrado")
+
+// Eventos emitidos pela API. Endpoints podem assinar eventos específicos,
+// prefixos ("messages.*") ou todos ("*").
+var KnownEvents = []string{
+	"messages.upsert",
+	"send.status",
+	"connection.update",
+	"qrcode.updated",
+	"pairingcode.updated",
+	"logged_out",
+	"history.sync",
+	"messages.update",
+	"groups.upsert",
+	"groups.update",
+	"groups.participants",
+	"presence.update",
+}
+
+// Endpoint é um webhook cadastrado para a instância
+type Endpoint struct {
+	ID         string            `json:"id"`
+	InstanceID string            `json:"instance_id"`
+	URL        string            `json:"url"`
+	Events     []string          `json:"events"`
+	Headers    map[string]string `json:"headers"`
+	Enabled    bool              `json:"enabled"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// Subscribes indica se o endpoint assina o evento
+func (e *Endpoint) Subscribes(event string) bool {
+	return matchEvent(e.Events, event)
+}
+
+func matchEvent(patterns []string, event string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if p == "*" || p == event {
+			return true
+		}
+		if strings.HasSuffix(p, ".*") && strings.HasPrefix(event, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate verifica URL e eventos antes de salvar
+func (e *Endpoint) Validate() error {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url deve começar com http:// ou https://")
+	}
+	for _, ev := range e.Events {
+		if !validPattern(ev) {
+			return fmt.Errorf("evento desconhecido: %q", ev)
+		}
+	}
+	return nil
+}
+
+// validPattern aceita "*", um evento conhecido ou um prefixo que casa com algum evento conhecido
+func validPattern(p string) bool {
+	if p == "*" {
+		return true
+	}
+	for _, ev := range KnownEvents {
+		if matchEvent([]string{p}, ev) {
+			return true
+		}
+	}
+	return false
+}
+
+const endpointColumns = `id, instance_id, url, events, headers, enabled, created_at, updated_at`
+
+func scanEndpoint(row rowScanner) (*Endpoint, error) {
+	var e Endpoint
+	var headers []byte
+	err := row.Scan(&e.ID, &e.InstanceID, &e.URL, pq.Array(&e.Events), &headers, &e.Enabled, &e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal(headers, &e.Headers)
+	if e.Events == nil {
+		e.Events = []string{}
+	}
+	if e.Headers == nil {
+		e.Headers = map[string]string{}
+	}
+	return &e, nil
+}
+
+// ListEndpoints lista os webhooks da instância
+func ListEndpoints(instanceID string) ([]Endpoint, error) {
+	rows, err := postgres.DB.Query(
+		`SELECT `+endpointColumns+` FROM webhooks WHERE instance_id = $1 ORDER BY created_at`, instanceID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao listar webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	endpoints := []Endpoint{}
+	for rows.Next() {
+		e, err := scanEndpoint(rows)
+		if err != nil {
+			continue
+		}
+		endpoints = append(endpoints, *e)
+	}
+	return endpoints, nil
+}
+
+// GetEndpoint busca um webhook da instância
+func GetEndpoint(instanceID, id string) (*Endpoint, error) {
+	e, err := scanEndpoint(postgres.DB.QueryRow(
+		`SELECT `+endpointColumns+` FROM webhooks WHERE id = $1 AND instance_id = $2`, id, instanceID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrEndpointNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar webhook: %w", err)
+	}
+	return e, nil
+}
+
+// CreateEndpoint cadastra um webhook
+func CreateEndpoint(e *Endpoint) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	headers, _ := json.Marshal(e.Headers)
+	err := postgres.DB.QueryRow(
+		`INSERT INTO webhooks (instance_id, url, events, headers, enabled) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at`,
+		e.InstanceID, e.URL, pq.Array(e.Events), headers, e.Enabled,
+	).Scan(&e.ID, &e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("erro ao salvar webhook: %w", err)
+	}
+	return nil
+}
+
+// UpdateEndpoint salva as alterações de um webhook
+func UpdateEndpoint(e *Endpoint) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	headers, _ := json.Marshal(e.Headers)
+	err := postgres.DB.QueryRow(
+		`UPDATE webhooks SET url = $1, events = $2, headers = $3, enabled = $4, updated_at = NOW()
+		WHERE id = $5 AND instance_id = $6
+		RETURNING updated_at`,
+		e.URL, pq.Array(e.Events), headers, e.Enabled, e.ID, e.InstanceID,
+	).Scan(&e.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrEndpointNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao atualizar webhook: %w", err)
+	}
+	return nil
+}
+
+// DeleteEndpoint remove o webhook e descarta as entregas ainda pendentes para ele
+func DeleteEndpoint(instanceID, id string) error {
+	postgres.DB.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = $1 AND status = 'pending'`, id)
+	res, err := postgres.DB.Exec(`DELETE FROM webhooks WHERE id = $1 AND instance_id = $2`, id, instanceID)
+	if err != nil {
+		return fmt.Errorf("erro ao remover webhook: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrEndpointNotFound
+	}
+	return nil
+}
+
+// subscribedEndpoints retorna os webhooks ativos da instância que assinam o evento
+func subscribedEndpoints(instanceID, event string) ([]Endpoint, error) {
+	rows, err := postgres.DB.Query(
+		`SELECT `+endpointColumns+` FROM webhooks WHERE instance_id = $1 AND enabled`, instanceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var endpoints []Endpoint
+	for rows.Next() {
+		e, err := scanEndpoint(rows)
+		if err != nil {
+			continue
+		}
+		if e.Subscribes(event) {
+			endpoints = append(endpoints, *e)
+		}
+	}
+	return endpoints, nil
+}
+
+// endpointHeaders busca os headers customizados atuais do webhook
+func endpointHeaders(webhookID string) map[string]string {
+	var raw []byte
+	postgres.DB.QueryRow(`SELECT headers FROM webhooks WHERE id = $1`, webhookID).Scan(&raw)
+	headers := map[string]string{}
+	json.Unmarshal(raw, &headers)
+	return headers
+}
@@ -0,0 +1,121 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"wapi/config"
+	"wapi/store/postgres"
+)
+
+const globalSettingsKey = "global_webhook"
+
+// GlobalConfig é o webhook do servidor que recebe eventos de todas as instâncias
+type GlobalConfig struct {
+	URL     string            `json:"url"`
+	Events  []string          `json:"events"`
+	Headers map[string]string `json:"headers"`
+	Secret  string            `json:"secret"`
+	Enabled bool              `json:"enabled"`
+}
+
+var global struct {
+	mu  sync.RWMutex
+	cfg GlobalConfig
+}
+
+// LoadGlobal carrega o webhook global: primeiro das variáveis de ambiente e,
+// se existir, da configuração salva pelo endpoint de admin
+func LoadGlobal() error {
+	cfg := GlobalConfig{
+		URL:     config.App.GlobalWebhookURL,
+		Events:  splitEvents(config.App.GlobalWebhookEvents),
+		Headers: map[string]string{},
+		Secret:  config.App.GlobalWebhookSecret,
+		Enabled: config.App.GlobalWebhookURL != "",
+	}
+
+	var raw []byte
+	err := postgres.DB.QueryRow(`SELECT value FROM settings WHERE key = $1`, globalSettingsKey).Scan(&raw)
+	if err == nil {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return fmt.Errorf("erro ao ler webhook global: %w", err)
+		}
+	}
+
+	if cfg.Enabled && cfg.Secret == "" {
+		// Sem GLOBAL_WEBHOOK_SECRET: deriva um segredo estável do JWT_SECRET
+		mac := hmac.New(sha256.New, []byte(config.App.JWTSecret))
+		mac.Write([]byte(globalSettingsKey))
+		cfg.Secret = "whsec_" + hex.EncodeToString(mac.Sum(nil))
+		log.Printf("[WEBHOOK] GLOBAL_WEBHOOK_SECRET não definido, usando segredo derivado (consulte GET /admin/webhook)")
+	}
+
+	global.mu.Lock()
+	global.cfg = cfg
+	global.mu.Unlock()
+	return nil
+}
+
+// GetGlobal retorna a configuração atual do webhook global
+func GetGlobal() GlobalConfig {
+	global.mu.RLock()
+	defer global.mu.RUnlock()
+	return global.cfg
+}
+
+// SetGlobal valida e persiste a configuração do webhook global
+func SetGlobal(cfg GlobalConfig) error {
+	if cfg.Enabled {
+		u, err := url.Parse(cfg.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("url deve começar com http:// ou https://")
+		}
+		if cfg.Secret == "" {
+			cfg.Secret = NewSecret()
+		}
+	}
+	for _, ev := range cfg.Events {
+		if !validPattern(ev) {
+			return fmt.Errorf("evento desconhecido: %q", ev)
+		}
+	}
+	if cfg.Events == nil {
+		cfg.Events = []string{}
+	}
+	if cfg.Headers == nil {
+		cfg.Headers = map[string]string{}
+	}
+
+	raw, _ := json.Marshal(cfg)
+	_, err := postgres.DB.Exec(
+		`INSERT INTO settings (key, value) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()`,
+		globalSettingsKey, raw,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao salvar webhook global: %w", err)
+	}
+
+	global.mu.Lock()
+	global.cfg = cfg
+	global.mu.Unlock()
+	return nil
+}
+
+func splitEvents(s string) []string {
+	events := []string{}
+	for _, ev := range strings.Split(s, ",") {
+		if ev = strings.TrimSpace(ev); ev != "" {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
@@ -2,10 +2,17 @@ package whatsapp
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
+	"wapi/config"
+	"wapi/internal/secure"
+	"wapi/store/postgres"
 
 	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
 	waLog "go.mau.fi/whatsmeow/util/log"
@@ -14,12 +21,73 @@ import (
 
 const sessionsDir = "/app/sessions"
 
+// Modos de armazenamento das sessões (SESSION_STORE)
+const (
+	StoreSQLite   = "sqlite"
+	StorePostgres = "postgres"
+)
+
+var (
+	pgOnce      sync.Once
+	pgDB        *sql.DB
+	pgContainer *sqlstore.Container
+	pgErr       error
+)
+
+// PostgresContainer retorna o store de sessões compartilhado no PostgreSQL.
+// Todas as instâncias usam as mesmas tabelas whatsmeow_*, separadas pelo JID
+// do device (instances.device_jid). Com MASTER_KEY as colunas de chaves e
+// sessões são cifradas pela conexão do store (ver pgcrypt.go).
+func PostgresContainer() (*sqlstore.Container, error) {
+	pgOnce.Do(func() {
+		pgErr = openPostgresContainer()
+		if pgErr != nil {
+			pgErr = fmt.Errorf("erro ao criar store postgres: %w", pgErr)
+		}
+	})
+	return pgContainer, pgErr
+}
+
+func openPostgresContainer() error {
+	db, err := openPostgresSessionDB(secure.Global)
+	if err != nil {
+		return err
+	}
+	container := sqlstore.NewWithDB(db, "postgres", waLog.Noop)
+	if err := container.Upgrade(context.Background()); err != nil {
+		db.Close()
+		return err
+	}
+	if err := preparePostgresStore(secure.Global); err != nil {
+		db.Close()
+		return err
+	}
+	pgDB, pgContainer = db, container
+	return nil
+}
+
+// SessionDBPath retorna o arquivo SQLite de sessão da instância
+func SessionDBPath(dir, instanceID string) string {
+	return fmt.Sprintf("%s/%s.db", dir, instanceID)
+}
+
 func NewClient(instanceID string) (*whatsmeow.Client, *sqlstore.Container, error) {
+	if config.App.SessionStore == StorePostgres {
+		return newPostgresClient(instanceID)
+	}
+
+	if secure.Enabled() {
+		return newEncryptedClient(instanceID)
+	}
+	if _, err := os.Stat(EncryptedDBPath(sessionsDir, instanceID)); err == nil {
+		return nil, nil, fmt.Errorf("sessão cifrada: configure a MASTER_KEY")
+	}
+
 	if err := os.MkdirAll(sessionsDir, 0755); err != nil {
 		return nil, nil, fmt.Errorf("erro ao criar diretório de sessões: %w", err)
 	}
 
-	dbPath := fmt.Sprintf("%s/%s.db", sessionsDir, instanceID)
+	dbPath := SessionDBPath(sessionsDir, instanceID)
 	container, err := sqlstore.New(context.Background(), "sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on", dbPath), waLog.Noop)
 	if err != nil {
 		return nil, nil, fmt.Errorf("erro ao criar store sqlite: %w", err)
@@ -34,6 +102,95 @@ func NewClient(instanceID string) (*whatsmeow.Client, *sqlstore.Container, error
 	return client, container, nil
 }
 
+func newEncryptedClient(instanceID string) (*whatsmeow.Client, *sqlstore.Container, error) {
+	session, err := openEncryptedSession(instanceID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	device, err := session.container.GetFirstDevice(context.Background())
+	if err != nil || device == nil {
+		device = session.container.NewDevice()
+	}
+
+	client := whatsmeow.NewClient(device, waLog.Noop)
+	return client, session.container, nil
+}
+
+func newPostgresClient(instanceID string) (*whatsmeow.Client, *sqlstore.Container, error) {
+	container, err := PostgresContainer()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var device *store.Device
+	var deviceJID string
+	postgres.DB.QueryRow(`SELECT device_jid FROM instances WHERE id = $1`, instanceID).Scan(&deviceJID)
+	if deviceJID != "" {
+		jid, err := types.ParseJID(deviceJID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("device_jid inválido: %w", err)
+		}
+		device, err = container.GetDevice(context.Background(), jid)
+		if err != nil {
+			return nil, nil, fmt.Errorf("erro ao carregar sessão: %w", err)
+		}
+	}
+	if device == nil {
+		device = container.NewDevice()
+	}
+
+	client := whatsmeow.NewClient(device, waLog.Noop)
+	return client, container, nil
+}
+
+// SaveDeviceJID associa o device pareado à instância
+func SaveDeviceJID(instanceID string, jid types.JID) {
+	postgres.DB.Exec(`UPDATE instances SET device_jid = $1 WHERE id = $2`, jid.String(), instanceID)
+}
+
+// CloseContainer fecha o store da instância. O store compartilhado do
+// PostgreSQL continua aberto para as demais instâncias e uma sessão cifrada
+// é gravada antes de fechar.
+func CloseContainer(container *sqlstore.Container) {
+	if container == nil || container == pgContainer {
+		return
+	}
+	if session := encryptedSessionFor(container); session != nil {
+		closeEncryptedSession(session.instanceID, true)
+		return
+	}
+	container.Close()
+}
+
+// DeleteSession remove a sessão da instância (após logout): o arquivo SQLite
+// (cifrado ou não) e a associação com o device no PostgreSQL
+func DeleteSession(instanceID string) error {
+	postgres.DB.Exec(`UPDATE instances SET device_jid = '' WHERE id = $1`, instanceID)
+
+	closeEncryptedSession(instanceID, false)
+	return removeSessionFiles(SessionDBPath(sessionsDir, instanceID))
+}
+
 func FormatPhone(phone string) types.JID {
 	return types.NewJID(phone, types.DefaultUserServer)
 }
+
+// ParseChatJID interpreta o chat informado na URL: JID completo, número de
+// telefone ou id de grupo (com hífen ou mais de 15 dígitos)
+func ParseChatJID(chat string) (types.JID, error) {
+	if strings.Contains(chat, "@") {
+		jid, err := types.ParseJID(chat)
+		if err != nil {
+			return types.EmptyJID, fmt.Errorf("jid inválido: %w", err)
+		}
+		return jid.ToNonAD(), nil
+	}
+	if chat == "" {
+		return types.EmptyJID, fmt.Errorf("jid inválido")
+	}
+	if strings.Contains(chat, "-") || len(chat) > 15 {
+		return types.NewJID(chat, types.GroupServer), nil
+	}
+	return types.NewJID(chat, types.DefaultUserServer), nil
+}
@@ -0,0 +1,537 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"wapi/internal/secure"
+
+	"github.com/mattn/go-sqlite3"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Espera entre o primeiro commit e a gravação da sessão cifrada. Os commits
+// desse intervalo (vários por mensagem, a cada passo do ratchet do Signal)
+// viram uma única gravação.
+const flushDelay = 500 * time.Millisecond
+
+// encryptedSession mantém a sessão SQLite da instância em memória e grava uma
+// cópia cifrada com a MASTER_KEY em <id>.db.enc logo depois das alterações,
+// agrupadas por flushDelay, e ao fechar. A sessão nunca fica em texto claro
+// no disco.
+type encryptedSession struct {
+	instanceID string
+	path       string
+	db         *sql.DB
+	container  *sqlstore.Container
+
+	changed chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+var (
+	encMu       sync.Mutex
+	encSessions = map[string]*encryptedSession{}
+)
+
+// EncryptedDBPath retorna o arquivo cifrado de sessão da instância
+func EncryptedDBPath(dir, instanceID string) string {
+	return SessionDBPath(dir, instanceID) + ".enc"
+}
+
+// openEncryptedSession carrega a sessão da instância em memória. Uma sessão já
+// aberta é reaproveitada, para um client novo não partir de um snapshot antigo.
+// Um arquivo em texto claro de antes da MASTER_KEY é cifrado e removido.
+func openEncryptedSession(instanceID string) (*encryptedSession, error) {
+	encMu.Lock()
+	defer encMu.Unlock()
+	if s, ok := encSessions[instanceID]; ok {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(sessionsDir, 0755); err != nil {
+		return nil, fmt.Errorf("erro ao criar diretório de sessões: %w", err)
+	}
+	connector := &sessionConnector{}
+	db := openSessionDB(connector)
+
+	path := EncryptedDBPath(sessionsDir, instanceID)
+	plainPath := SessionDBPath(sessionsDir, instanceID)
+	migrated := false
+	if data, err := os.ReadFile(path); err == nil {
+		image, err := secure.Global.Decrypt(data)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("erro ao decifrar sessão: %w", err)
+		}
+		if err := loadImage(db, image); err != nil {
+			db.Close()
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		db.Close()
+		return nil, fmt.Errorf("erro ao ler sessão: %w", err)
+	} else if _, err := os.Stat(plainPath); err == nil {
+		if err := loadFile(db, plainPath); err != nil {
+			db.Close()
+			return nil, err
+		}
+		migrated = true
+	}
+
+	container := sqlstore.NewWithDB(db, "sqlite3", waLog.Noop)
+	if err := container.Upgrade(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("erro ao criar store sqlite: %w", err)
+	}
+
+	s := &encryptedSession{
+		instanceID: instanceID,
+		path:       path,
+		db:         db,
+		container:  container,
+		changed:    make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	// Grava o arquivo cifrado (antes de remover o original, se houver) e só
+	// então liga a gravação das alterações, para um carregamento que falhe
+	// no meio nunca sobrescrever a sessão boa
+	if err := s.flush(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	connector.onChange = s.notify
+	if migrated {
+		removeSessionFiles(plainPath)
+		log.Printf("[SESSION] Sessão %s cifrada com a MASTER_KEY", instanceID)
+	}
+	encSessions[instanceID] = s
+	go s.run()
+	return s, nil
+}
+
+// notify agenda a gravação. Não bloqueia: é chamado dentro do commit.
+func (s *encryptedSession) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// run grava a sessão flushDelay depois de cada leva de alterações
+func (s *encryptedSession) run() {
+	defer close(s.done)
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.changed:
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(flushDelay):
+		}
+		if err := s.flush(); err != nil {
+			log.Printf("[SESSION] Erro ao gravar sessão %s: %v", s.instanceID, err)
+		}
+	}
+}
+
+// flush grava o snapshot cifrado da sessão
+func (s *encryptedSession) flush() error {
+	return withConn(s.db, func(c *sqlite3.SQLiteConn) error {
+		image, err := c.Serialize("main")
+		if err != nil {
+			return fmt.Errorf("erro ao serializar sessão: %w", err)
+		}
+		return writeEncrypted(s.path, image, secure.Global)
+	})
+}
+
+// close para a gravação em segundo plano e fecha o banco em memória. Com
+// save, as últimas alterações são gravadas antes.
+func (s *encryptedSession) close(save bool) {
+	close(s.stop)
+	<-s.done
+	if save {
+		if err := s.flush(); err != nil {
+			log.Printf("[SESSION] Erro ao gravar sessão %s: %v", s.instanceID, err)
+		}
+	}
+	s.container.Close()
+}
+
+// closeEncryptedSession fecha a sessão aberta da instância, se houver
+func closeEncryptedSession(instanceID string, save bool) {
+	encMu.Lock()
+	s, ok := encSessions[instanceID]
+	delete(encSessions, instanceID)
+	encMu.Unlock()
+	if ok {
+		s.close(save)
+	}
+}
+
+// encryptedSessionFor retorna a sessão aberta dona do container
+func encryptedSessionFor(container *sqlstore.Container) *encryptedSession {
+	encMu.Lock()
+	defer encMu.Unlock()
+	for _, s := range encSessions {
+		if s.container == container {
+			return s
+		}
+	}
+	return nil
+}
+
+// flushEncryptedSession grava a sessão da instância, se estiver aberta
+func flushEncryptedSession(instanceID string) error {
+	encMu.Lock()
+	s, ok := encSessions[instanceID]
+	encMu.Unlock()
+	if !ok {
+		return nil
+	}
+	return s.flush()
+}
+
+// FlushSessions grava as sessões cifradas abertas. Chamado no desligamento.
+func FlushSessions() {
+	encMu.Lock()
+	sessions := make([]*encryptedSession, 0, len(encSessions))
+	for _, s := range encSessions {
+		sessions = append(sessions, s)
+	}
+	encMu.Unlock()
+
+	for _, s := range sessions {
+		if err := s.flush(); err != nil {
+			log.Printf("[SESSION] Erro ao gravar sessão %s: %v", s.instanceID, err)
+		}
+	}
+}
+
+// openMemoryDB abre um banco SQLite em memória. O banco pertence a uma única
+// conexão, então o pool fica limitado a ela e nunca a descarta.
+func openMemoryDB() (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", memoryDSN)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir sessão em memória: %w", err)
+	}
+	singleConn(db)
+	return db, nil
+}
+
+// openSessionDB abre o banco em memória de uma sessão cifrada, presa à única
+// conexão criada pelo connector
+func openSessionDB(connector *sessionConnector) *sql.DB {
+	db := sql.OpenDB(connector)
+	singleConn(db)
+	return db
+}
+
+func singleConn(db *sql.DB) {
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+}
+
+const memoryDSN = "file::memory:?_foreign_keys=on"
+
+// sessionConnector cria a única conexão do banco em memória de uma sessão
+// cifrada. O banco só existe nessa conexão: se o pool tentar abrir outra (ela
+// viria vazia), Connect falha em vez de devolver uma sessão perdida. Cada
+// commit avisa onChange, que agenda a gravação (ver encryptedSession.run).
+type sessionConnector struct {
+	mu        sync.Mutex
+	connected bool
+	onChange  func()
+}
+
+func (sc *sessionConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.connected {
+		return nil, errSessionConnLost
+	}
+	conn, err := sc.Driver().Open(memoryDSN)
+	if err != nil {
+		return nil, err
+	}
+	c := conn.(*sqlite3.SQLiteConn)
+	// Dentro do hook a conexão não pode ser usada: ele só agenda a gravação
+	c.RegisterCommitHook(func() int {
+		if sc.onChange != nil {
+			sc.onChange()
+		}
+		return 0
+	})
+	sc.connected = true
+	return c, nil
+}
+
+func (sc *sessionConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{}
+}
+
+var errSessionConnLost = errors.New("conexão da sessão em memória perdida")
+
+// withConn executa fn com a conexão SQLite nativa do banco
+func withConn(db *sql.DB, fn func(*sqlite3.SQLiteConn) error) error {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("conexão sqlite inesperada: %T", driverConn)
+		}
+		return fn(c)
+	})
+}
+
+// copyDB copia o conteúdo de src para dst com a API de backup do SQLite
+func copyDB(dst, src *sql.DB) error {
+	return withConn(dst, func(dstConn *sqlite3.SQLiteConn) error {
+		return withConn(src, func(srcConn *sqlite3.SQLiteConn) error {
+			backup, err := dstConn.Backup("main", srcConn, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// loadImage carrega um banco serializado em db. O Deserialize gera um banco de
+// tamanho fixo, por isso a imagem é copiada para o banco que vai crescer.
+func loadImage(db *sql.DB, image []byte) error {
+	tmp, err := openMemoryDB()
+	if err != nil {
+		return err
+	}
+	defer tmp.Close()
+	err = withConn(tmp, func(c *sqlite3.SQLiteConn) error {
+		return c.Deserialize(image, "main")
+	})
+	if err != nil {
+		return fmt.Errorf("erro ao carregar sessão: %w", err)
+	}
+	if err := copyDB(db, tmp); err != nil {
+		return fmt.Errorf("erro ao carregar sessão: %w", err)
+	}
+	return nil
+}
+
+// loadFile carrega um arquivo de sessão em texto claro em db
+func loadFile(db *sql.DB, path string) error {
+	src, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on", path))
+	if err != nil {
+		return fmt.Errorf("erro ao abrir sessão sqlite: %w", err)
+	}
+	defer src.Close()
+	if err := copyDB(db, src); err != nil {
+		return fmt.Errorf("erro ao carregar sessão sqlite: %w", err)
+	}
+	return nil
+}
+
+// openSessionFile abre um arquivo de sessão SQLite. Um arquivo .enc é
+// decifrado com a MASTER_KEY para um banco em memória.
+func openSessionFile(path string) (*sql.DB, error) {
+	if !strings.HasSuffix(path, ".enc") {
+		db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on", path))
+		if err != nil {
+			return nil, fmt.Errorf("erro ao abrir sessão sqlite: %w", err)
+		}
+		return db, nil
+	}
+
+	if !secure.Enabled() {
+		return nil, fmt.Errorf("sessão cifrada: configure a MASTER_KEY")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler sessão: %w", err)
+	}
+	image, err := secure.Global.Decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao decifrar sessão: %w", err)
+	}
+	db, err := openMemoryDB()
+	if err != nil {
+		return nil, err
+	}
+	if err := loadImage(db, image); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func serialize(db *sql.DB) ([]byte, error) {
+	var image []byte
+	err := withConn(db, func(c *sqlite3.SQLiteConn) error {
+		var err error
+		image, err = c.Serialize("main")
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao serializar sessão: %w", err)
+	}
+	return image, nil
+}
+
+// writeEncrypted cifra a imagem e grava o arquivo de forma atômica
+func writeEncrypted(path string, image []byte, c *secure.Cipher) error {
+	data, err := c.Encrypt(image)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(path, data)
+}
+
+func writeAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("erro ao gravar sessão: %w", err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		// O arquivo precisa estar no disco antes do rename substituir o anterior
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("erro ao gravar sessão: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("erro ao gravar sessão: %w", err)
+	}
+	return nil
+}
+
+func removeSessionFiles(dbPath string) error {
+	for _, path := range []string{dbPath, dbPath + "-wal", dbPath + "-shm", dbPath + ".enc"} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("erro ao remover sessão: %w", err)
+		}
+	}
+	return nil
+}
+
+// rotationCiphers cria os ciphers da rotação. Uma das chaves pode ficar vazia.
+func rotationCiphers(oldKey, newKey string) (oldCipher, newCipher *secure.Cipher, err error) {
+	if oldKey != "" {
+		if oldCipher, err = secure.NewCipher(oldKey); err != nil {
+			return nil, nil, fmt.Errorf("chave atual: %w", err)
+		}
+	}
+	if newKey != "" {
+		if newCipher, err = secure.NewCipher(newKey); err != nil {
+			return nil, nil, fmt.Errorf("chave nova: %w", err)
+		}
+	}
+	if oldCipher == nil && newCipher == nil {
+		return nil, nil, secure.ErrNoMasterKey
+	}
+	return oldCipher, newCipher, nil
+}
+
+// RotateSessions recifra os arquivos de sessão do diretório da chave oldKey
+// para newKey. Com oldKey vazia, as sessões em texto claro são cifradas; com
+// newKey vazia, são decifradas de volta para <id>.db. O servidor deve estar
+// parado. Retorna o total de sessões processadas.
+func RotateSessions(dir, oldKey, newKey string) (int, error) {
+	oldCipher, newCipher, err := rotationCiphers(oldKey, newKey)
+	if err != nil {
+		return 0, err
+	}
+
+	var paths []string
+	if oldCipher != nil {
+		paths, err = filepath.Glob(filepath.Join(dir, "*.db.enc"))
+	} else {
+		paths, err = filepath.Glob(filepath.Join(dir, "*.db"))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, path := range paths {
+		if err := rotateSession(path, oldCipher, newCipher); err != nil {
+			return total, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		total++
+	}
+	return total, nil
+}
+
+func rotateSession(path string, oldCipher, newCipher *secure.Cipher) error {
+	var image []byte
+	plainPath := strings.TrimSuffix(path, ".enc")
+	if oldCipher == nil {
+		db, err := openMemoryDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if err := loadFile(db, path); err != nil {
+			return err
+		}
+		if image, err = serialize(db); err != nil {
+			return err
+		}
+	} else {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if image, err = oldCipher.Decrypt(data); err != nil {
+			return err
+		}
+	}
+
+	if newCipher == nil {
+		if err := writeAtomic(plainPath, image); err != nil {
+			return err
+		}
+		return os.Remove(path)
+	}
+	if err := writeEncrypted(plainPath+".enc", image, newCipher); err != nil {
+		return err
+	}
+	if oldCipher == nil {
+		for _, p := range []string{plainPath, plainPath + "-wal", plainPath + "-shm"} {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
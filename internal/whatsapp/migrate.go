@@ -0,0 +1,64 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// MigrateSQLiteSession copia a sessão de um arquivo SQLite (cifrado ou não)
+// para o store do PostgreSQL e associa o device à instância. Retorna o JID
+// migrado e o total de linhas copiadas. Linhas que já existem no PostgreSQL
+// são mantidas.
+func MigrateSQLiteSession(instanceID, dbPath string) (types.JID, int, error) {
+	if _, err := PostgresContainer(); err != nil {
+		return types.EmptyJID, 0, err
+	}
+
+	src, err := openSessionFile(dbPath)
+	if err != nil {
+		return types.EmptyJID, 0, err
+	}
+	defer src.Close()
+
+	// Atualiza o schema do arquivo antes da cópia
+	container := sqlstore.NewWithDB(src, "sqlite3", waLog.Noop)
+	if err := container.Upgrade(context.Background()); err != nil {
+		return types.EmptyJID, 0, fmt.Errorf("erro ao abrir sessão sqlite: %w", err)
+	}
+	device, err := container.GetFirstDevice(context.Background())
+	if err != nil {
+		return types.EmptyJID, 0, fmt.Errorf("erro ao ler device: %w", err)
+	}
+	if device == nil || device.ID == nil {
+		return types.EmptyJID, 0, fmt.Errorf("sessão não pareada")
+	}
+	jid := *device.ID
+
+	dump, err := readSession(src, "?", jid.String(), true)
+	if err != nil {
+		return jid, 0, err
+	}
+
+	// Pela conexão do store, que cifra as colunas com a MASTER_KEY
+	tx, err := pgDB.Begin()
+	if err != nil {
+		return jid, 0, err
+	}
+	defer tx.Rollback()
+
+	total, err := writeSession(tx, dump, true)
+	if err != nil {
+		return jid, total, err
+	}
+	if _, err := tx.Exec(`UPDATE instances SET device_jid = $1 WHERE id = $2`, jid.String(), instanceID); err != nil {
+		return jid, total, err
+	}
+	if err := tx.Commit(); err != nil {
+		return jid, total, err
+	}
+	return jid, total, nil
+}
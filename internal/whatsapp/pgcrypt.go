@@ -0,0 +1,391 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"wapi/internal/secure"
+	"wapi/store/postgres"
+
+	"github.com/lib/pq"
+)
+
+// Com MASTER_KEY e SESSION_STORE=postgres, as colunas bytea das tabelas
+// whatsmeow_* (chaves, sessões Signal, segredos de mensagens) são cifradas
+// pela conexão do store: cada valor []byte enviado ao banco é cifrado e cada
+// bytea lido é decifrado, sem mudar o whatsmeow. A cifra é determinística
+// (ver secure.EncryptDeterministic) para as buscas por igualdade em colunas
+// bytea, como index_mac, continuarem funcionando.
+
+// openPostgresSessionDB abre o pool usado pelo store de sessões do PostgreSQL
+func openPostgresSessionDB(c *secure.Cipher) (*sql.DB, error) {
+	connector, err := pq.NewConnector(postgres.DSN())
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return sql.OpenDB(connector), nil
+	}
+	return sql.OpenDB(&cipherConnector{Connector: connector, cipher: c}), nil
+}
+
+type cipherConnector struct {
+	driver.Connector
+	cipher *secure.Cipher
+}
+
+func (cc *cipherConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := cc.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &cipherConn{Conn: conn, cipher: cc.cipher}, nil
+}
+
+// cipherConn repassa tudo à conexão do lib/pq, cifrando os argumentos e
+// decifrando as linhas lidas
+type cipherConn struct {
+	driver.Conn
+	cipher *secure.Cipher
+}
+
+func (c *cipherConn) encryptArgs(args []driver.NamedValue) []driver.NamedValue {
+	out := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		if b, ok := arg.Value.([]byte); ok && b != nil {
+			arg.Value = c.cipher.EncryptDeterministic(b)
+		}
+		out[i] = arg
+	}
+	return out
+}
+
+func (c *cipherConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (c *cipherConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return execer.ExecContext(ctx, query, c.encryptArgs(args))
+}
+
+func (c *cipherConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rows, err := queryer.QueryContext(ctx, query, c.encryptArgs(args))
+	if err != nil {
+		return nil, err
+	}
+	return newCipherRows(rows, c.cipher), nil
+}
+
+func (c *cipherConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cipherStmt{Stmt: stmt, conn: c}, nil
+}
+
+func (c *cipherConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *cipherConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *cipherConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *cipherConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *cipherConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+type cipherStmt struct {
+	driver.Stmt
+	conn *cipherConn
+}
+
+func (s *cipherStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return nil, errors.New("driver sem suporte a ExecContext")
+	}
+	return execer.ExecContext(ctx, s.conn.encryptArgs(args))
+}
+
+func (s *cipherStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return nil, errors.New("driver sem suporte a QueryContext")
+	}
+	rows, err := queryer.QueryContext(ctx, s.conn.encryptArgs(args))
+	if err != nil {
+		return nil, err
+	}
+	return newCipherRows(rows, s.conn.cipher), nil
+}
+
+func (s *cipherStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	return s.conn.CheckNamedValue(nv)
+}
+
+// cipherRows decifra as colunas bytea. Valores sem o prefixo de secure.Encrypt
+// (gravados antes da MASTER_KEY) passam como estão.
+type cipherRows struct {
+	driver.Rows
+	cipher *secure.Cipher
+	bytea  []bool
+}
+
+func newCipherRows(rows driver.Rows, c *secure.Cipher) *cipherRows {
+	r := &cipherRows{Rows: rows, cipher: c, bytea: make([]bool, len(rows.Columns()))}
+	if typed, ok := rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		for i := range r.bytea {
+			r.bytea[i] = typed.ColumnTypeDatabaseTypeName(i) == "BYTEA"
+		}
+	}
+	return r
+}
+
+func (r *cipherRows) Next(dest []driver.Value) error {
+	if err := r.Rows.Next(dest); err != nil {
+		return err
+	}
+	for i, v := range dest {
+		b, ok := v.([]byte)
+		if !ok || !r.bytea[i] || !secure.IsEncrypted(b) {
+			continue
+		}
+		plain, err := r.cipher.Decrypt(b)
+		if err != nil {
+			return fmt.Errorf("erro ao decifrar sessão: %w", err)
+		}
+		dest[i] = plain
+	}
+	return nil
+}
+
+// preparePostgresStore ajusta as tabelas do whatsmeow à MASTER_KEY depois do
+// Upgrade do schema: com a chave, remove os CHECKs de tamanho das colunas
+// bytea (o valor cifrado é maior) e cifra os valores ainda em texto claro;
+// sem a chave, recusa um store que já tem valores cifrados.
+func preparePostgresStore(c *secure.Cipher) error {
+	if c == nil {
+		encrypted, err := postgresStoreEncrypted()
+		if err != nil {
+			return err
+		}
+		if encrypted {
+			return fmt.Errorf("sessões cifradas no PostgreSQL: configure a MASTER_KEY")
+		}
+		return nil
+	}
+	if err := dropLengthChecks(); err != nil {
+		return err
+	}
+	_, err := recryptPostgresStore(nil, c)
+	return err
+}
+
+// postgresStoreEncrypted indica se algum device do store foi cifrado
+func postgresStoreEncrypted() (bool, error) {
+	rows, err := postgres.DB.Query(`SELECT noise_key FROM whatsmeow_device`)
+	if err != nil {
+		return false, fmt.Errorf("erro ao verificar store de sessões: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key []byte
+		if err := rows.Scan(&key); err != nil {
+			return false, err
+		}
+		if secure.IsEncrypted(key) {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// dropLengthChecks remove os CHECK (length(...) = N) das tabelas whatsmeow_*
+func dropLengthChecks() error {
+	rows, err := postgres.DB.Query(
+		`SELECT conrelid::regclass::text, conname FROM pg_constraint
+		WHERE contype = 'c' AND conrelid::regclass::text LIKE 'whatsmeow\_%'
+			AND pg_get_constraintdef(oid) LIKE '%length(%'`,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao listar restrições do store: %w", err)
+	}
+	var drops []string
+	for rows.Next() {
+		var table, name string
+		if err := rows.Scan(&table, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		drops = append(drops, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s",
+			pq.QuoteIdentifier(table), pq.QuoteIdentifier(name)))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, stmt := range drops {
+		if _, err := postgres.DB.Exec(stmt); err != nil {
+			return fmt.Errorf("erro ao ajustar store de sessões: %w", err)
+		}
+	}
+	return nil
+}
+
+// recryptPostgresStore regrava as colunas bytea das tabelas whatsmeow_* da
+// chave from para a chave to, numa única transação. from nil lê texto claro
+// e to nil grava texto claro. Retorna o total de valores regravados.
+func recryptPostgresStore(from, to *secure.Cipher) (int, error) {
+	tx, err := postgres.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	columns, err := byteaColumns(tx)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, col := range columns {
+		n, err := recryptColumn(tx, col[0], col[1], from, to)
+		if err != nil {
+			return total, fmt.Errorf("erro ao cifrar %s.%s: %w", col[0], col[1], err)
+		}
+		total += n
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+func byteaColumns(tx *sql.Tx) ([][2]string, error) {
+	rows, err := tx.Query(
+		`SELECT table_name, column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name LIKE 'whatsmeow\_%' AND data_type = 'bytea'
+		ORDER BY table_name, ordinal_position`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns [][2]string
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, [2]string{table, column})
+	}
+	return columns, rows.Err()
+}
+
+func recryptColumn(tx *sql.Tx, table, column string, from, to *secure.Cipher) (int, error) {
+	query := fmt.Sprintf(`SELECT ctid::text, %s FROM %s WHERE %s IS NOT NULL`,
+		pq.QuoteIdentifier(column), pq.QuoteIdentifier(table), pq.QuoteIdentifier(column))
+	rows, err := tx.Query(query)
+	if err != nil {
+		return 0, err
+	}
+	type update struct {
+		ctid  string
+		value []byte
+	}
+	var updates []update
+	for rows.Next() {
+		var ctid string
+		var value []byte
+		if err := rows.Scan(&ctid, &value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		plain := value
+		if secure.IsEncrypted(value) {
+			if from == nil {
+				// Já cifrado (inicialização depois de uma migração anterior)
+				continue
+			}
+			if plain, err = from.Decrypt(value); err != nil {
+				rows.Close()
+				return 0, err
+			}
+		} else if to == nil {
+			continue
+		}
+		next := plain
+		if to != nil {
+			next = to.EncryptDeterministic(plain)
+		}
+		updates = append(updates, update{ctid, next})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	stmt := fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE ctid = $2::tid`, pq.QuoteIdentifier(table), pq.QuoteIdentifier(column))
+	for _, u := range updates {
+		if _, err := tx.Exec(stmt, u.value, u.ctid); err != nil {
+			return 0, err
+		}
+	}
+	return len(updates), nil
+}
+
+// RotatePostgresSessions recifra o store de sessões do PostgreSQL da chave
+// oldKey para newKey, com as mesmas regras de RotateSessions. O banco precisa
+// estar conectado (postgres.Connect) e o servidor parado.
+func RotatePostgresSessions(oldKey, newKey string) (int, error) {
+	oldCipher, newCipher, err := rotationCiphers(oldKey, newKey)
+	if err != nil {
+		return 0, err
+	}
+	if newCipher != nil {
+		if err := dropLengthChecks(); err != nil {
+			return 0, err
+		}
+	}
+	return recryptPostgresStore(oldCipher, newCipher)
+}
@@ -0,0 +1,341 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"wapi/config"
+	"wapi/internal/secure"
+	"wapi/store/postgres"
+
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// sessionTables lista as tabelas do whatsmeow na ordem das foreign keys e a
+// coluna com o JID do device. whatsmeow_lid_map é global (sem coluna de device).
+var sessionTables = []struct {
+	Name      string
+	JIDColumn string
+}{
+	{"whatsmeow_device", "jid"},
+	{"whatsmeow_identity_keys", "our_jid"},
+	{"whatsmeow_pre_keys", "jid"},
+	{"whatsmeow_sessions", "our_jid"},
+	{"whatsmeow_sender_keys", "our_jid"},
+	{"whatsmeow_app_state_sync_keys", "jid"},
+	{"whatsmeow_app_state_version", "jid"},
+	{"whatsmeow_app_state_mutation_macs", "jid"},
+	{"whatsmeow_contacts", "our_jid"},
+	{"whatsmeow_chat_settings", "our_jid"},
+	{"whatsmeow_message_secrets", "our_jid"},
+	{"whatsmeow_privacy_tokens", "our_jid"},
+	{"whatsmeow_event_buffer", "our_jid"},
+	{"whatsmeow_lid_map", ""},
+}
+
+// SessionDump é uma cópia das linhas do device store de uma instância,
+// independente do banco (SQLite ou PostgreSQL) de origem
+type SessionDump struct {
+	JID    string      `json:"jid"`
+	Tables []TableDump `json:"tables"`
+}
+
+type TableDump struct {
+	Name    string           `json:"name"`
+	Columns []string         `json:"columns"`
+	Rows    [][]sessionValue `json:"rows"`
+}
+
+// sessionValue preserva o tipo dos valores na serialização JSON: bytes viram
+// {"b": "<base64>"} e inteiros são lidos de volta como int64
+type sessionValue struct {
+	V interface{}
+}
+
+func (v sessionValue) MarshalJSON() ([]byte, error) {
+	if b, ok := v.V.([]byte); ok {
+		return json.Marshal(map[string][]byte{"b": b})
+	}
+	return json.Marshal(v.V)
+}
+
+func (v *sessionValue) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var wrapped map[string][]byte
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return err
+		}
+		v.V = wrapped["b"]
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	if n, ok := raw.(json.Number); ok {
+		i, err := n.Int64()
+		if err != nil {
+			return fmt.Errorf("valor numérico inválido: %s", n)
+		}
+		raw = i
+	}
+	v.V = raw
+	return nil
+}
+
+// ExportSession lê o device store da instância no modo de armazenamento atual.
+// O whatsmeow_lid_map não é exportado: no PostgreSQL ele é compartilhado entre
+// as instâncias e o whatsmeow o reconstrói conforme as mensagens chegam.
+func ExportSession(instanceID string) (*SessionDump, error) {
+	var jid string
+	postgres.DB.QueryRow(`SELECT device_jid FROM instances WHERE id = $1`, instanceID).Scan(&jid)
+
+	if config.App.SessionStore == StorePostgres {
+		if jid == "" {
+			return nil, fmt.Errorf("instância não pareada")
+		}
+		// Pela conexão do store, que decifra as colunas com a MASTER_KEY
+		if _, err := PostgresContainer(); err != nil {
+			return nil, err
+		}
+		return readSession(pgDB, "$1", jid, false)
+	}
+
+	dbPath := SessionDBPath(sessionsDir, instanceID)
+	if secure.Enabled() {
+		if err := flushEncryptedSession(instanceID); err != nil {
+			return nil, err
+		}
+		// Sessões ainda não cifradas continuam no arquivo original
+		if _, err := os.Stat(EncryptedDBPath(sessionsDir, instanceID)); err == nil {
+			dbPath = EncryptedDBPath(sessionsDir, instanceID)
+		}
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("instância não pareada")
+	}
+	src, err := openSessionFile(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	if err := src.QueryRow(`SELECT jid FROM whatsmeow_device LIMIT 1`).Scan(&jid); err != nil {
+		return nil, fmt.Errorf("instância não pareada")
+	}
+	return readSession(src, "?", jid, false)
+}
+
+// ImportSession grava o device store na instância (no modo de armazenamento
+// atual) e associa o device a ela. Deve ser chamado antes de criar o client.
+func ImportSession(instanceID string, dump *SessionDump) error {
+	if config.App.SessionStore == StorePostgres {
+		if _, err := PostgresContainer(); err != nil {
+			return err
+		}
+		tx, err := pgDB.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := writeSession(tx, dump, true); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE instances SET device_jid = $1 WHERE id = $2`, dump.JID, instanceID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if err := os.MkdirAll(sessionsDir, 0755); err != nil {
+		return fmt.Errorf("erro ao criar diretório de sessões: %w", err)
+	}
+	var dst *sql.DB
+	var err error
+	if secure.Enabled() {
+		dst, err = openMemoryDB()
+	} else {
+		dst, err = openSessionFile(SessionDBPath(sessionsDir, instanceID))
+	}
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	// Cria o schema do whatsmeow no banco novo
+	container := sqlstore.NewWithDB(dst, "sqlite3", waLog.Noop)
+	if err := container.Upgrade(context.Background()); err != nil {
+		return fmt.Errorf("erro ao criar store sqlite: %w", err)
+	}
+	tx, err := dst.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := writeSession(tx, dump, false); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if secure.Enabled() {
+		image, err := serialize(dst)
+		if err != nil {
+			return err
+		}
+		if err := writeEncrypted(EncryptedDBPath(sessionsDir, instanceID), image, secure.Global); err != nil {
+			return err
+		}
+	}
+	postgres.DB.Exec(`UPDATE instances SET device_jid = $1 WHERE id = $2`, dump.JID, instanceID)
+	return nil
+}
+
+// readSession lê as linhas do device jid. placeholder é "?" (SQLite) ou "$1" (PostgreSQL).
+func readSession(db *sql.DB, placeholder, jid string, includeLIDMap bool) (*SessionDump, error) {
+	dump := &SessionDump{JID: jid}
+	for _, table := range sessionTables {
+		if table.JIDColumn == "" && !includeLIDMap {
+			continue
+		}
+		t, err := readTable(db, table.Name, table.JIDColumn, placeholder, jid)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler %s: %w", table.Name, err)
+		}
+		dump.Tables = append(dump.Tables, *t)
+	}
+	return dump, nil
+}
+
+func readTable(db *sql.DB, table, jidColumn, placeholder, jid string) (*TableDump, error) {
+	query := "SELECT * FROM " + table
+	var args []interface{}
+	if jidColumn != "" {
+		query += " WHERE " + jidColumn + " = " + placeholder
+		args = append(args, jid)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	t := &TableDump{Name: table, Columns: columns, Rows: [][]sessionValue{}}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		row := make([]sessionValue, len(columns))
+		for i, v := range values {
+			row[i] = sessionValue{V: v}
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t, rows.Err()
+}
+
+// writeSession insere as linhas do dump, mantendo as que já existem no destino.
+// No PostgreSQL só as colunas existentes são gravadas, com os tipos ajustados.
+func writeSession(tx *sql.Tx, dump *SessionDump, toPostgres bool) (int, error) {
+	total := 0
+	for _, t := range dump.Tables {
+		var pgTypes map[string]string
+		if toPostgres {
+			var err error
+			if pgTypes, err = columnTypes(tx, t.Name); err != nil {
+				return total, err
+			}
+		}
+
+		var columns, placeholders []string
+		var indexes []int
+		for i, col := range t.Columns {
+			if toPostgres {
+				if _, ok := pgTypes[col]; !ok {
+					continue
+				}
+				placeholders = append(placeholders, fmt.Sprintf("$%d", len(columns)+1))
+			} else {
+				placeholders = append(placeholders, "?")
+			}
+			columns = append(columns, col)
+			indexes = append(indexes, i)
+		}
+		insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING",
+			t.Name, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+
+		for _, row := range t.Rows {
+			params := make([]interface{}, len(indexes))
+			for j, i := range indexes {
+				params[j] = row[i].V
+				if toPostgres {
+					params[j] = convertValue(row[i].V, pgTypes[t.Columns[i]])
+				}
+			}
+			if _, err := tx.Exec(insert, params...); err != nil {
+				return total, fmt.Errorf("erro ao gravar %s: %w", t.Name, err)
+			}
+			total++
+		}
+	}
+	return total, nil
+}
+
+// columnTypes retorna o tipo de cada coluna da tabela no PostgreSQL
+func columnTypes(tx *sql.Tx, table string) (map[string]string, error) {
+	rows, err := tx.Query(
+		`SELECT column_name, data_type FROM information_schema.columns WHERE table_name = $1`, table,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := map[string]string{}
+	for rows.Next() {
+		var name, dataType string
+		if err := rows.Scan(&name, &dataType); err != nil {
+			return nil, err
+		}
+		result[name] = dataType
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("tabela %s não existe no PostgreSQL", table)
+	}
+	return result, rows.Err()
+}
+
+// convertValue ajusta as diferenças de tipo entre SQLite e PostgreSQL:
+// booleanos gravados como inteiro e texto/blob trocados
+func convertValue(v interface{}, pgType string) interface{} {
+	switch pgType {
+	case "boolean":
+		if n, ok := v.(int64); ok {
+			return n != 0
+		}
+	case "bytea":
+		if s, ok := v.(string); ok {
+			return []byte(s)
+		}
+	case "text", "uuid", "character varying":
+		if b, ok := v.([]byte); ok {
+			return string(b)
+		}
+	}
+	return v
+}
@@ -3,6 +3,8 @@ package postgres
 import (
 	"database/sql"
 	"fmt"
+	"log"
+	"regexp"
 	"wapi/config"
 
 	_ "github.com/lib/pq"
@@ -10,8 +12,9 @@ import (
 
 var DB *sql.DB
 
-func Connect() error {
-	dsn := fmt.Sprintf(
+// DSN retorna a string de conexão com o PostgreSQL da configuração
+func DSN() string {
+	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		config.App.PostgresHost,
 		config.App.PostgresPort,
@@ -19,8 +22,31 @@ func Connect() error {
 		config.App.PostgresPassword,
 		config.App.PostgresDB,
 	)
+}
+
+// Nomes de configuração de busca textual válidos (ex.: portuguese, english, simple)
+var searchLanguagePattern = regexp.MustCompile(`^[a-z_]+$`)
+
+// SearchLanguage retorna o dicionário da busca textual nas mensagens. Como o
+// nome vai direto no SQL (o índice só é usado com uma constante), valores
+// fora do padrão voltam para portuguese.
+func SearchLanguage() string {
+	lang := config.App.SearchLanguage
+	if !searchLanguagePattern.MatchString(lang) {
+		log.Printf("SEARCH_LANGUAGE inválido: %q, usando portuguese", lang)
+		return "portuguese"
+	}
+	return lang
+}
 
-	db, err := sql.Open("postgres", dsn)
+// SearchVector é a expressão indexada da busca textual: texto ou legenda e
+// transcrição. As consultas precisam usar exatamente a mesma expressão.
+func SearchVector() string {
+	return fmt.Sprintf("to_tsvector('%s', coalesce(body, '') || ' ' || coalesce(transcription, ''))", SearchLanguage())
+}
+
+func Connect() error {
+	db, err := sql.Open("postgres", DSN())
 	if err != nil {
 		return fmt.Errorf("erro ao abrir conexão: %w", err)
 	}
@@ -47,10 +73,14 @@ func Migrate() error {
 		name VARCHAR(255) UNIQUE NOT NULL,
 		api_key VARCHAR(255) UNIQUE NOT NULL,
 		webhook_url TEXT DEFAULT '',
+		webhook_secret VARCHAR(255) DEFAULT '',
 		transcription_enabled BOOLEAN DEFAULT FALSE,
 		typing_delay_min INTEGER DEFAULT 1000,
 		typing_delay_max INTEGER DEFAULT 3000,
 		status VARCHAR(50) DEFAULT 'disconnected',
+		status_reason TEXT DEFAULT '',
+		ban_expires_at TIMESTAMP,
+		device_jid VARCHAR(255) DEFAULT '',
 		phone VARCHAR(50) DEFAULT '',
 		created_at TIMESTAMP DEFAULT NOW(),
 		updated_at TIMESTAMP DEFAULT NOW()
@@ -61,6 +91,154 @@ func Migrate() error {
                 token TEXT NOT NULL,
                 created_at TIMESTAMP DEFAULT NOW()
         );
+
+	CREATE TABLE IF NOT EXISTS outbound_jobs (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		seq BIGSERIAL,
+		instance_id UUID NOT NULL REFERENCES instances(id) ON DELETE CASCADE,
+		recipient VARCHAR(255) NOT NULL,
+		kind VARCHAR(20) NOT NULL,
+		message TEXT DEFAULT '',
+		media_url TEXT DEFAULT '',
+		media_data BYTEA,
+		mimetype VARCHAR(255) DEFAULT '',
+		filename TEXT DEFAULT '',
+		caption TEXT DEFAULT '',
+		media_type VARCHAR(20) DEFAULT '',
+		status VARCHAR(20) DEFAULT 'queued',
+		attempts INTEGER DEFAULT 0,
+		max_attempts INTEGER DEFAULT 5,
+		next_attempt_at TIMESTAMP DEFAULT NOW(),
+		last_error TEXT DEFAULT '',
+		message_id VARCHAR(255) DEFAULT '',
+		server_timestamp TIMESTAMP,
+		recipient_jid VARCHAR(255) DEFAULT '',
+		created_at TIMESTAMP DEFAULT NOW(),
+		updated_at TIMESTAMP DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_outbound_jobs_pending ON outbound_jobs (instance_id, status, seq);
+
+	CREATE TABLE IF NOT EXISTS settings (
+		key VARCHAR(100) PRIMARY KEY,
+		value JSONB NOT NULL,
+		updated_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		instance_id UUID NOT NULL REFERENCES instances(id) ON DELETE CASCADE,
+		url TEXT NOT NULL,
+		events TEXT[] DEFAULT '{}',
+		headers JSONB DEFAULT '{}',
+		enabled BOOLEAN DEFAULT TRUE,
+		created_at TIMESTAMP DEFAULT NOW(),
+		updated_at TIMESTAMP DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhooks_instance ON webhooks (instance_id);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		instance_id UUID NOT NULL REFERENCES instances(id) ON DELETE CASCADE,
+		webhook_id UUID REFERENCES webhooks(id) ON DELETE SET NULL,
+		global BOOLEAN DEFAULT FALSE,
+		replay BOOLEAN DEFAULT FALSE,
+		url TEXT NOT NULL,
+		event VARCHAR(100) NOT NULL,
+		payload JSONB NOT NULL,
+		status VARCHAR(20) DEFAULT 'pending',
+		attempts INTEGER DEFAULT 0,
+		max_attempts INTEGER DEFAULT 8,
+		next_attempt_at TIMESTAMP DEFAULT NOW(),
+		last_status_code INTEGER DEFAULT 0,
+		last_error TEXT DEFAULT '',
+		delivered_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT NOW(),
+		updated_at TIMESTAMP DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending ON webhook_deliveries (status, next_attempt_at);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_instance ON webhook_deliveries (instance_id, created_at);
+
+	CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		delivery_id UUID NOT NULL,
+		instance_id UUID NOT NULL REFERENCES instances(id) ON DELETE CASCADE,
+		webhook_id UUID REFERENCES webhooks(id) ON DELETE SET NULL,
+		global BOOLEAN DEFAULT FALSE,
+		url TEXT NOT NULL,
+		event VARCHAR(100) NOT NULL,
+		payload JSONB NOT NULL,
+		attempts INTEGER DEFAULT 0,
+		last_status_code INTEGER DEFAULT 0,
+		last_error TEXT DEFAULT '',
+		replayed_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_dead_letters_instance ON webhook_dead_letters (instance_id, created_at);
+
+	CREATE TABLE IF NOT EXISTS instance_state_transitions (
+		id BIGSERIAL PRIMARY KEY,
+		instance_id UUID NOT NULL REFERENCES instances(id) ON DELETE CASCADE,
+		from_status VARCHAR(50) NOT NULL,
+		to_status VARCHAR(50) NOT NULL,
+		reason TEXT DEFAULT '',
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS idx_instance_state_transitions ON instance_state_transitions (instance_id, id);
+
+	CREATE TABLE IF NOT EXISTS messages (
+		id BIGSERIAL PRIMARY KEY,
+		instance_id UUID NOT NULL REFERENCES instances(id) ON DELETE CASCADE,
+		message_id VARCHAR(255) NOT NULL,
+		chat_jid VARCHAR(255) NOT NULL,
+		sender_jid VARCHAR(255) DEFAULT '',
+		push_name VARCHAR(255) DEFAULT '',
+		direction VARCHAR(10) NOT NULL,
+		type VARCHAR(30) NOT NULL,
+		body TEXT DEFAULT '',
+		transcription TEXT DEFAULT '',
+		media JSONB,
+		status VARCHAR(20) DEFAULT '',
+		timestamp TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		updated_at TIMESTAMP DEFAULT NOW(),
+		UNIQUE (instance_id, chat_jid, message_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_messages_chat ON messages (instance_id, chat_jid, timestamp DESC, id DESC);
+	CREATE INDEX IF NOT EXISTS idx_messages_message_id ON messages (instance_id, message_id);
+
+	CREATE TABLE IF NOT EXISTS chats (
+		instance_id UUID NOT NULL REFERENCES instances(id) ON DELETE CASCADE,
+		chat_jid VARCHAR(255) NOT NULL,
+		name VARCHAR(255) DEFAULT '',
+		is_group BOOLEAN DEFAULT FALSE,
+		last_message_id VARCHAR(255) DEFAULT '',
+		last_message_type VARCHAR(30) DEFAULT '',
+		last_message_preview TEXT DEFAULT '',
+		last_message_from_me BOOLEAN DEFAULT FALSE,
+		last_message_at TIMESTAMP,
+		last_activity_at TIMESTAMP,
+		unread_count INTEGER DEFAULT 0,
+		archived BOOLEAN DEFAULT FALSE,
+		pinned BOOLEAN DEFAULT FALSE,
+		muted BOOLEAN DEFAULT FALSE,
+		muted_until TIMESTAMP,
+		created_at TIMESTAMP DEFAULT NOW(),
+		updated_at TIMESTAMP DEFAULT NOW(),
+		PRIMARY KEY (instance_id, chat_jid)
+	);
+	CREATE INDEX IF NOT EXISTS idx_chats_activity ON chats (instance_id, last_activity_at DESC);
+
+	CREATE TABLE IF NOT EXISTS pending_receipts (
+		instance_id UUID NOT NULL REFERENCES instances(id) ON DELETE CASCADE,
+		chat_jid VARCHAR(255) NOT NULL,
+		message_id VARCHAR(255) NOT NULL,
+		status VARCHAR(20) NOT NULL,
+		status_at TIMESTAMP NOT NULL,
+		participant VARCHAR(255) NOT NULL DEFAULT '',
+		received_at TIMESTAMP DEFAULT NOW(),
+		PRIMARY KEY (instance_id, chat_jid, message_id, status, participant)
+	);
+	CREATE INDEX IF NOT EXISTS idx_pending_receipts_received ON pending_receipts (received_at);
 	`
 
 	_, err := DB.Exec(query)
@@ -72,5 +250,40 @@ func Migrate() error {
 	DB.Exec(`ALTER TABLE instances ALTER COLUMN company_id DROP NOT NULL`)
 	DB.Exec(`ALTER TABLE users ALTER COLUMN company_id DROP NOT NULL`)
 
+	// Colunas adicionadas depois da criação das tabelas
+	DB.Exec(`ALTER TABLE instances ADD COLUMN IF NOT EXISTS webhook_secret VARCHAR(255) DEFAULT ''`)
+	DB.Exec(`ALTER TABLE instances ADD COLUMN IF NOT EXISTS status_reason TEXT DEFAULT ''`)
+	DB.Exec(`ALTER TABLE instances ADD COLUMN IF NOT EXISTS ban_expires_at TIMESTAMP`)
+	DB.Exec(`ALTER TABLE instances ADD COLUMN IF NOT EXISTS device_jid VARCHAR(255) DEFAULT ''`)
+	DB.Exec(`ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS webhook_id UUID REFERENCES webhooks(id) ON DELETE SET NULL`)
+	DB.Exec(`ALTER TABLE webhook_dead_letters ADD COLUMN IF NOT EXISTS webhook_id UUID REFERENCES webhooks(id) ON DELETE SET NULL`)
+	DB.Exec(`ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS global BOOLEAN DEFAULT FALSE`)
+	DB.Exec(`ALTER TABLE webhook_dead_letters ADD COLUMN IF NOT EXISTS global BOOLEAN DEFAULT FALSE`)
+	DB.Exec(`ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS replay BOOLEAN DEFAULT FALSE`)
+	DB.Exec(`ALTER TABLE outbound_jobs ADD COLUMN IF NOT EXISTS message_id VARCHAR(255) DEFAULT ''`)
+	DB.Exec(`ALTER TABLE outbound_jobs ADD COLUMN IF NOT EXISTS server_timestamp TIMESTAMP`)
+	DB.Exec(`ALTER TABLE outbound_jobs ADD COLUMN IF NOT EXISTS recipient_jid VARCHAR(255) DEFAULT ''`)
+	DB.Exec(`ALTER TABLE instances ADD COLUMN IF NOT EXISTS history_days INTEGER DEFAULT 30`)
+	DB.Exec(`ALTER TABLE instances ADD COLUMN IF NOT EXISTS emit_from_me BOOLEAN DEFAULT FALSE`)
+	DB.Exec(`ALTER TABLE instances ADD COLUMN IF NOT EXISTS auto_read BOOLEAN DEFAULT FALSE`)
+	DB.Exec(`ALTER TABLE messages ADD COLUMN IF NOT EXISTS media_download JSONB`)
+	DB.Exec(`ALTER TABLE messages ADD COLUMN IF NOT EXISTS status_at TIMESTAMP`)
+	DB.Exec(`ALTER TABLE messages ADD COLUMN IF NOT EXISTS raw_sender_jid VARCHAR(255) DEFAULT ''`)
+	DB.Exec(`ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP`)
+	DB.Exec(`ALTER TABLE messages ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP`)
+
+	// Índice da busca textual, um por dicionário configurado
+	_, err = DB.Exec(fmt.Sprintf(
+		`CREATE INDEX IF NOT EXISTS idx_messages_search_%s ON messages USING GIN ((%s))`,
+		SearchLanguage(), SearchVector(),
+	))
+	if err != nil {
+		return fmt.Errorf("erro ao criar índice de busca (SEARCH_LANGUAGE=%s): %w", SearchLanguage(), err)
+	}
+
+	// API keys e tokens passam a ser guardados como hash (ver secure.HashSecret)
+	DB.Exec(`UPDATE instances SET api_key = 'sha256:' || encode(sha256(convert_to(api_key, 'UTF8')), 'hex') WHERE api_key NOT LIKE 'sha256:%'`)
+	DB.Exec(`UPDATE api_tokens SET token = 'sha256:' || encode(sha256(convert_to(token, 'UTF8')), 'hex') WHERE token NOT LIKE 'sha256:%'`)
+
 	return nil
 }